@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pinguo-icc/mongodbcli/checkpoint"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/material"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// materialOptions 构建素材迁移类命令的参数
+func materialOptions(rt *runtime) (*material.Options, error) {
+	m, err := rt.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	opt := &material.Options{
+		Matrix:      m,
+		Concurrency: rt.opt.Concurrency,
+		EventsFile:  rt.opt.EventsFile,
+		Report:      rt.report,
+		IDs:         rt.ids,
+		OnResults:   rt.addResults,
+	}
+	if opt.MQ, err = rt.mqProfile(); err != nil {
+		return nil, err
+	}
+	if rt.run != nil {
+		opt.DeadLetters = events.NewDeadLetters(rt.mongo, rt.opt.AdminDB, rt.run.ID, rt.action)
+	}
+
+	return opt, nil
+}
+
+// materialRun 适配 material 包中按目标空间执行的命令
+func materialRun(fn func(context.Context, *mongo.Client, *material.Options) error) func(context.Context, *runtime) error {
+	return func(ctx context.Context, rt *runtime) error {
+		opt, err := materialOptions(rt)
+		if err != nil {
+			return err
+		}
+
+		return fn(ctx, rt.mongo, opt)
+	}
+}
+
+// withIDFiles 为命令添加 --include-ids-file/--exclude-ids-file, 加载的列表经 materialOptions 传给 material 包
+// 执行结束后输出各列表匹配到及未找到的ID数
+// c.idChoice 为 true 时需指定 --include-ids-file 或 --all 之一
+func withIDFiles(c *command) *command {
+	var include, exclude string
+	all := false
+
+	setFlags, run := c.setFlags, c.run
+	c.setFlags = func(fs *flag.FlagSet) {
+		if setFlags != nil {
+			setFlags(fs)
+		}
+		fs.StringVar(&include, "include-ids-file", "",
+			"only process the documents listed in the file, one id per line or scope,env,id per line")
+		fs.StringVar(&exclude, "exclude-ids-file", "",
+			"skip the documents listed in the file, same format as --include-ids-file, wins over the include list")
+		if c.idChoice {
+			fs.BoolVar(&all, "all", false,
+				"process every document of every target space, required unless --include-ids-file is set")
+		}
+	}
+	c.run = func(ctx context.Context, rt *runtime) error {
+		if c.idChoice {
+			switch {
+			case include == "" && !all:
+				return fmt.Errorf("%s used to only process the hard-coded videobeats_operation ids, "+
+					"set --include-ids-file=material-include-ids.csv to keep that or --all to process every document", c.name)
+			case include != "" && all:
+				return errors.New("--all and --include-ids-file can't be used together")
+			case all:
+				log.Printf("WARNING: --all is set, %s will process every document in every target space\n", c.name)
+			}
+		}
+
+		if include == "" && exclude == "" {
+			return run(ctx, rt)
+		}
+
+		f := &idlist.Filter{}
+		var err error
+		if include != "" {
+			if f.Include, err = idlist.Load(include); err != nil {
+				return err
+			}
+		}
+		if exclude != "" {
+			if f.Exclude, err = idlist.Load(exclude); err != nil {
+				return err
+			}
+		}
+
+		rt.ids = f
+		err = run(ctx, rt)
+		f.PrintSummary(os.Stdout)
+
+		return err
+	}
+
+	return c
+}
+
+// syncCommand 构建按源集合记录断点、支持 --resume 续传及批量写入的同步命令
+func syncCommand(name, desc string, fn func(context.Context, *mongo.Client, *material.Options) error) *command {
+	resume := false
+	batch := material.SyncBatch{}
+
+	return withIDFiles(&command{
+		name:   name,
+		desc:   desc,
+		conns:  connMongo,
+		writes: []string{"operational_materials"},
+		setFlags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&resume, "resume", false, "continue each source collection from its last checkpoint instead of the beginning")
+			fs.IntVar(&batch.Size, "batch-size", material.DefaultBatchSize, "documents read and upserted with one bulk write per batch")
+			fs.BoolVar(&batch.Ordered, "ordered", false, "stop writing the rest of a batch at its first failed document")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+			opt.Checkpoints = checkpoint.New(rt.mongo, rt.opt.AdminDB, rt.action, rt.run.ID, resume)
+			opt.Batch = batch
+
+			return fn(ctx, rt.mongo, opt)
+		},
+	})
+}
+
+// convertSyncCommand 需按字段表转换旧数据的同步命令, 额外支持 --strict
+func convertSyncCommand(name, desc string, fn func(context.Context, *mongo.Client, *material.Options) error) *command {
+	strict := false
+	c := syncCommand(name, desc, func(ctx context.Context, cli *mongo.Client, opt *material.Options) error {
+		opt.Strict = strict
+
+		return fn(ctx, cli, opt)
+	})
+
+	setFlags := c.setFlags
+	c.setFlags = func(fs *flag.FlagSet) {
+		setFlags(fs)
+		fs.BoolVar(&strict, "strict", false,
+			"reject documents whose enum values cannot be parsed into the sync report instead of storing zero values")
+	}
+
+	return c
+}
+
+func init() {
+	syncMaterials := convertSyncCommand(
+		"syncMaterials",
+		"sync materials from operations_materials to operational_materials",
+		material.SyncMaterials,
+	)
+	syncMaterials.idChoice = true
+	register(syncMaterials)
+
+	register(withIDFiles(&command{
+		name:  "syncUnitFontMaterials",
+		desc:  "resend create events of unityFont materials",
+		conns: connMongo,
+		run:   materialRun(material.SyncUnityFontMaterials),
+	}))
+
+	register(convertSyncCommand(
+		"syncMaterialCategorys",
+		"sync material categories from operations_materials to operational_materials",
+		material.SyncMaterialCategorys,
+	))
+
+	register(syncCommand(
+		"syncMaterialsPosition",
+		"sync material positions from material-positions-v2 to operational_materials",
+		material.SyncMaterialsPosition,
+	))
+
+	register(syncCommand(
+		"syncMaterialsPlan",
+		"sync material position plans from material-positions-v2 to operational_materials",
+		material.SyncMaterialsPlan,
+	))
+
+	register(&command{
+		name:  "audit-materials",
+		desc:  "validate every version of migrated materials and categories against their field definitions, read only",
+		conns: connMongo,
+		run:   materialRun(material.AuditMaterials),
+	})
+
+	register(&command{
+		name:    "clearMaterials",
+		desc:    "delete materials updated after 2022-12-01 from operational_materials",
+		conns:   connMongo,
+		writes:  []string{"operational_materials"},
+		oneShot: true,
+		run:     materialRun(material.ClearMaterials),
+	})
+
+	allowInvalid := false
+	register(withIDFiles(&command{
+		name:   "syncH5Style",
+		desc:   "extract h5 style/attribute of activities into h5.properties",
+		conns:  connMongo,
+		writes: []string{"h5"},
+		setFlags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&allowInvalid, "allow-invalid", false,
+				"still sync and publish activities whose html5Style is malformed or has mistyped keys, unknown keys never block the sync")
+		},
+		run: materialRun(func(ctx context.Context, client *mongo.Client, opt *material.Options) error {
+			return syncH5Style(ctx, client, opt, allowInvalid)
+		}),
+	}))
+
+	register(withIDFiles(&command{
+		name:     "dealWithPlanBytraverse",
+		desc:     "create override material versions for plans that override vip or period",
+		conns:    connMongo,
+		writes:   []string{"operational_materials"},
+		oneShot:  true,
+		idChoice: true,
+		run:      materialRun(material.DealWithPlanBytraverse),
+	}))
+
+	register(&command{
+		name:       "resetCategoryVersionID",
+		desc:       "reset material category version id to the one of the operation space",
+		conns:      connMongo,
+		writes:     []string{"operational_materials"},
+		sourceEnvs: []string{"operation"},
+		oneShot:    true,
+		run:        materialRun(material.ResetMaterialCategoryVersionID),
+	})
+
+	register(&command{
+		name:       "resetH5StyleMainID",
+		desc:       "reset h5 properties id to the one of the operation space",
+		conns:      connMongo,
+		writes:     []string{"h5"},
+		sourceEnvs: []string{"operation"},
+		oneShot:    true,
+		run:        materialRun(resetH5StyleMainID),
+	})
+
+	register(&command{
+		name:   "resetH5Type",
+		desc:   "reset the type of h5 activity nodes to the type of their root",
+		conns:  connMongo,
+		writes: []string{"operational-positions"},
+		run: materialRun(func(ctx context.Context, client *mongo.Client, opt *material.Options) error {
+			return exec(ctx, client, opt, fixH5ActivityType)
+		}),
+	})
+
+	register(&command{
+		name:   "resetH5Active",
+		desc:   "reset the active status of h5 activity nodes to the one of their parent",
+		conns:  connMongo,
+		writes: []string{"operational-positions"},
+		run: materialRun(func(ctx context.Context, client *mongo.Client, opt *material.Options) error {
+			return exec(ctx, client, opt, fixH5ActivityActStatus)
+		}),
+	})
+
+	register(&command{
+		name:    "DealwithMaterialCategoryParentID",
+		desc:    "clear material category parent when it equals the category type",
+		conns:   connMongo,
+		writes:  []string{"operational_materials"},
+		oneShot: true,
+		run:     materialRun(material.DealwithMaterialCategoryParentID),
+	})
+
+	register(&command{
+		name:  "mapOfBmallAndOPS",
+		desc:  "build the id relationship between bmall and ops materials",
+		conns: connMongo | connBmall,
+		run: func(ctx context.Context, rt *runtime) error {
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			res, err := material.CreateMapBetweenBmallAndOpsID(ctx, rt.mongo, rt.bmall, opt)
+			if res != nil {
+				res.Print(os.Stdout)
+			}
+
+			return err
+		},
+	})
+
+	verify := &material.VerifyMappingOptions{}
+	register(&command{
+		name:  "verify-bmall-mapping",
+		desc:  "cross-check bmall.id_relationship against ops materials for dangling, stale, missing and duplicate mappings",
+		conns: connMongo | connBmall,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&verify.Prune, "prune", false, "delete relationships no live material uses")
+			fs.BoolVar(&verify.Repair, "repair", false,
+				"point relationships to the only live material using the bmall id and insert the missing ones")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			res, err := material.VerifyBmallMapping(ctx, rt.mongo, rt.bmall, opt, verify)
+			if res != nil {
+				res.Print(os.Stdout)
+			}
+
+			return err
+		},
+	})
+
+	register(&command{
+		name:    "initUgcCategoryVersionName",
+		desc:    "rename the default version of ugc categories to 初始版本",
+		conns:   connMongo,
+		writes:  []string{"operational_ugc"},
+		oneShot: true,
+		run:     materialRun(initUgcCategoryVersionName),
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	fix := false
+
+	register(&command{
+		name:   "check-activity-tree",
+		desc:   "report orphans, cycles, rootID mismatches, type/active inconsistencies and deleted parents of activity trees",
+		conns:  connMongo,
+		writes: []string{"operational-positions"},
+		setFlags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&fix, "fix", false, "reset the type and active status of inconsistent nodes to the ones of their root")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			err = exec(ctx, rt.mongo, opt, func(ctx context.Context, coll *mongo.Collection) error {
+				return checkActivityTree(ctx, coll, spaceOf(opt.Matrix, coll.Database().Name()), rt.report, fix)
+			})
+			rt.report.PrintFieldSummary(os.Stdout)
+
+			return err
+		},
+	})
+}
+
+// activityNode 活动树中的节点
+type activityNode struct {
+	Activity  `bson:",inline"`
+	IsDeleted bool `bson:"isDeleted"`
+}
+
+func (n *activityNode) isRoot() bool {
+	return n.PID.IsZero()
+}
+
+// treeIssue 活动树中的一处问题, field 为有问题的字段
+type treeIssue struct {
+	node  *activityNode
+	field string
+	err   error
+	// root 节点所属的根节点, 仅 type、active 不一致时有值, 用于修复
+	root *activityNode
+}
+
+// findTreeIssues 检查节点间的关系, 已删除的节点只作为父节点参与检查
+func findTreeIssues(nodes []*activityNode) []treeIssue {
+	byID := make(map[primitive.ObjectID]*activityNode, len(nodes))
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+
+	issues := []treeIssue{}
+	for _, n := range nodes {
+		if n.IsDeleted {
+			continue
+		}
+
+		if n.isRoot() {
+			if n.RootID != n.ID {
+				issues = append(issues, treeIssue{node: n, field: "rootID",
+					err: fmt.Errorf("root has rootID %s", n.RootID.Hex())})
+			}
+
+			continue
+		}
+
+		parent, ok := byID[n.PID]
+		if !ok {
+			issues = append(issues, treeIssue{node: n, field: "pid",
+				err: fmt.Errorf("parent %s not found", n.PID.Hex())})
+
+			continue
+		}
+		if parent.IsDeleted {
+			issues = append(issues, treeIssue{node: n, field: "isDeleted",
+				err: fmt.Errorf("parent %s is deleted", parent.ID.Hex())})
+		}
+
+		root, err := rootOf(n, byID)
+		if err != nil {
+			issues = append(issues, treeIssue{node: n, field: "pid", err: err})
+
+			continue
+		}
+		if n.RootID != root.ID {
+			issues = append(issues, treeIssue{node: n, field: "rootID",
+				err: fmt.Errorf("rootID %s, the root is %s", n.RootID.Hex(), root.ID.Hex())})
+		}
+		if n.Type != root.Type {
+			issues = append(issues, treeIssue{node: n, field: "type", root: root,
+				err: fmt.Errorf("type %d, the root is %d", n.Type, root.Type)})
+		}
+		if n.Active != root.Active {
+			issues = append(issues, treeIssue{node: n, field: "active", root: root,
+				err: fmt.Errorf("active %t, the root is %t", n.Active, root.Active)})
+		}
+	}
+
+	return issues
+}
+
+// rootOf 沿 pid 查找根节点, 遇到环或缺失的祖先时返回错误
+func rootOf(n *activityNode, byID map[primitive.ObjectID]*activityNode) (*activityNode, error) {
+	seen := map[primitive.ObjectID]bool{n.ID: true}
+	for !n.isRoot() {
+		p, ok := byID[n.PID]
+		if !ok {
+			return nil, fmt.Errorf("ancestor %s not found", n.PID.Hex())
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("cycle through %s", p.ID.Hex())
+		}
+		seen[p.ID] = true
+		n = p
+	}
+
+	return n, nil
+}
+
+// spaceOf 库名所属的空间, 不属于 m 中任一空间时返回零值
+func spaceOf(m config.Matrix, db string) config.Space {
+	for _, sp := range m.Spaces() {
+		if strings.HasPrefix(db, sp.String()+"_") {
+			return sp
+		}
+	}
+
+	return config.Space{}
+}
+
+// checkActivityTree 检查集合中全部活动树, 问题写入报告, fix 为 true 时按根节点批量修正 type 及 active
+func checkActivityTree(ctx context.Context, coll *mongo.Collection, sp config.Space, rep *report.Report, fix bool) error {
+	projection := primitive.M{
+		"_id":       1,
+		"rootID":    1,
+		"name":      1,
+		"pid":       1,
+		"type":      1,
+		"active":    1,
+		"isDeleted": 1,
+	}
+
+	cur, err := coll.Find(ctx, primitive.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+
+	var nodes []*activityNode
+	if err := cur.All(ctx, &nodes); err != nil {
+		return err
+	}
+
+	issues := findTreeIssues(nodes)
+	log.Printf("%s.%s %d nodes checked, %d issues found\n", coll.Database().Name(), coll.Name(), len(nodes), len(issues))
+
+	for _, is := range issues {
+		rep.Fail(coll, is.node.ID.Hex(), report.Record{
+			Kind:  report.KindActivity,
+			Field: is.field,
+			Class: report.ClassValidation,
+			Scope: sp.Scope,
+			Env:   sp.Env,
+		}, is.err)
+	}
+
+	if !fix {
+		return nil
+	}
+
+	return fixTreeIssues(ctx, coll, issues)
+}
+
+// fixTreeIssues 与 resetH5Type、resetH5Active 相同的修正, 每个根节点每个字段一次 UpdateMany
+func fixTreeIssues(ctx context.Context, coll *mongo.Collection, issues []treeIssue) error {
+	type group struct {
+		root  *activityNode
+		field string
+		ids   []primitive.ObjectID
+	}
+
+	groups := map[string]*group{}
+	list := []*group{}
+	for _, is := range issues {
+		if is.root == nil {
+			continue
+		}
+
+		key := is.root.ID.Hex() + "/" + is.field
+		g, ok := groups[key]
+		if !ok {
+			g = &group{root: is.root, field: is.field}
+			groups[key] = g
+			list = append(list, g)
+		}
+		g.ids = append(g.ids, is.node.ID)
+	}
+
+	for _, g := range list {
+		var value interface{} = g.root.Type
+		if g.field == "active" {
+			value = g.root.Active
+		}
+
+		u, err := store.Wrap(coll).UpdateMany(ctx,
+			primitive.M{"_id": op.In(g.ids)},
+			primitive.M{"$set": primitive.M{g.field: value}},
+		)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("%s %d nodes has been reset %s %v under root %s\n", coll.Name(), u.ModifiedCount, g.field, value, g.root.ID.Hex())
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFindTreeIssues(t *testing.T) {
+	node := func(pid, root primitive.ObjectID, typ int, active, deleted bool) *activityNode {
+		n := &activityNode{IsDeleted: deleted}
+		n.ID, n.PID, n.RootID, n.Type, n.Active = primitive.NewObjectID(), pid, root, typ, active
+		if root.IsZero() {
+			n.RootID = n.ID
+		}
+
+		return n
+	}
+
+	root := node(primitive.NilObjectID, primitive.NilObjectID, 2, true, false)
+	child := node(root.ID, root.ID, 3, true, false)
+	deleted := node(root.ID, root.ID, 2, true, true)
+	grandchild := node(deleted.ID, primitive.NewObjectID(), 2, false, false)
+	orphan := node(primitive.NewObjectID(), root.ID, 2, true, false)
+	a := node(primitive.NilObjectID, root.ID, 2, true, false)
+	b := node(a.ID, root.ID, 2, true, false)
+	a.PID = b.ID
+
+	got := map[string]int{}
+	for _, is := range findTreeIssues([]*activityNode{root, child, deleted, grandchild, orphan, a, b}) {
+		got[is.node.ID.Hex()+"/"+is.field]++
+	}
+
+	for _, want := range []string{
+		child.ID.Hex() + "/type",
+		grandchild.ID.Hex() + "/isDeleted",
+		grandchild.ID.Hex() + "/rootID",
+		grandchild.ID.Hex() + "/active",
+		orphan.ID.Hex() + "/pid",
+		a.ID.Hex() + "/pid",
+		b.ID.Hex() + "/pid",
+	} {
+		if got[want] != 1 {
+			t.Errorf("missing issue %s", want)
+		}
+		delete(got, want)
+	}
+	if len(got) > 0 {
+		t.Errorf("unexpected issues %v", got)
+	}
+}
+
+func TestSpaceOf(t *testing.T) {
+	m := config.Matrix{"camera360": {"prod", "dev"}, "mix": {"prod"}}
+	for db, want := range map[string]config.Space{
+		"camera360_dev_operational-positions": {Scope: "camera360", Env: "dev"},
+		"mix_prod_operational-positions":      {Scope: "mix", Env: "prod"},
+		"other_prod_operational-positions":    {},
+	} {
+		if got := spaceOf(m, db); got != want {
+			t.Errorf("spaceOf(%s) = %+v, want %+v", db, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfill 对每个目标空间的 <scope>_<env>_<db>.<coll> 执行 UpdateMany
+type backfill struct {
+	db, coll       string
+	filter, update string
+}
+
+// backfillPresets 常用的补字段操作, 命令行参数可覆盖其中的任一项
+var backfillPresets = map[string]backfill{
+	// 添加物料类型
+	"activity-type": {
+		db: "operational-positions", coll: "activity",
+		filter: `{"type": {"$exists": false}}`, update: `{"$set": {"type": 1}}`,
+	},
+	// 修复运营物料上下架状态
+	"activity-active": {
+		db: "operational-positions", coll: "activity",
+		filter: `{"active": null}`, update: `{"$set": {"active": false}}`,
+	},
+	// 添加软删除
+	"soft-delete": {
+		db: "operational-positions", coll: "activity",
+		filter: `{"isDeleted": {"$exists": false}}`, update: `{"$set": {"isDeleted": false}}`,
+	},
+}
+
+func backfillPresetNames() []string {
+	names := make([]string, 0, len(backfillPresets))
+	for k := range backfillPresets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	var (
+		preset string
+		b      backfill
+	)
+
+	register(&command{
+		name:  "backfill-field",
+		desc:  "update the documents matching a filter in one collection of every target space, e.g. to add a missing field",
+		conns: connMongo,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&preset, "preset", "", "fill the other flags with a preset: "+strings.Join(backfillPresetNames(), ", "))
+			fs.StringVar(&b.db, "db", "", "the database without the <scope>_<env>_ prefix, e.g. operational-positions")
+			fs.StringVar(&b.coll, "collection", "", "the collection to update")
+			fs.StringVar(&b.filter, "filter", "", `extended JSON filter, e.g. {"isDeleted": {"$exists": false}}`)
+			fs.StringVar(&b.update, "update", "", `extended JSON update document, e.g. {"$set": {"isDeleted": false}}`)
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			if preset != "" {
+				p, ok := backfillPresets[preset]
+				if !ok {
+					return fmt.Errorf("unknown --preset %q, use one of %s", preset, strings.Join(backfillPresetNames(), ", "))
+				}
+				b = b.withDefaults(p)
+			}
+
+			filter, update, err := b.parse()
+			if err != nil {
+				return err
+			}
+
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			counts := &backfillCounts{matched: map[string]int64{}, modified: map[string]int64{}}
+			e := &dbEntity{dbName: b.db, coll: []string{b.coll}}
+			err = opt.Run(ctx, e.units(rt.mongo, opt.Matrix, func(ctx context.Context, coll *mongo.Collection) error {
+				res, err := store.Wrap(coll).UpdateMany(ctx, filter, update)
+				if err != nil {
+					return err
+				}
+				counts.add(coll.Database().Name(), res)
+				log.Printf("%s.%s %d matched, %d modified\n", coll.Database().Name(), coll.Name(), res.MatchedCount, res.ModifiedCount)
+
+				return nil
+			}))
+			counts.print(os.Stdout)
+
+			return err
+		},
+	})
+}
+
+// withDefaults 未通过命令行指定的项使用 p 中的值
+func (b backfill) withDefaults(p backfill) backfill {
+	for _, f := range []struct{ v, def *string }{
+		{&b.db, &p.db}, {&b.coll, &p.coll}, {&b.filter, &p.filter}, {&b.update, &p.update},
+	} {
+		if *f.v == "" {
+			*f.v = *f.def
+		}
+	}
+
+	return b
+}
+
+// parse 解析 filter 及 update, update 必须全部为 $ 开头的更新操作符
+func (b backfill) parse() (filter, update bson.D, err error) {
+	if b.db == "" || b.coll == "" {
+		return nil, nil, errors.New("please set --db and --collection")
+	}
+	if b.filter == "" || b.update == "" {
+		return nil, nil, errors.New(`please set --filter and --update, use --filter='{}' to update every document`)
+	}
+
+	if err := bson.UnmarshalExtJSON([]byte(b.filter), false, &filter); err != nil {
+		return nil, nil, fmt.Errorf("invalid --filter: %w", err)
+	}
+	if err := bson.UnmarshalExtJSON([]byte(b.update), false, &update); err != nil {
+		return nil, nil, fmt.Errorf("invalid --update: %w", err)
+	}
+
+	if len(update) == 0 {
+		return nil, nil, errors.New("empty --update")
+	}
+	for _, e := range update {
+		if !strings.HasPrefix(e.Key, "$") {
+			return nil, nil, fmt.Errorf("--update key %q is not an update operator such as $set", e.Key)
+		}
+	}
+
+	return filter, update, nil
+}
+
+// backfillCounts 各库匹配及修改的文档数, dry-run 时修改数等于匹配数
+type backfillCounts struct {
+	mu       sync.Mutex
+	matched  map[string]int64
+	modified map[string]int64
+}
+
+func (c *backfillCounts) add(db string, res *mongo.UpdateResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.matched[db] += res.MatchedCount
+	c.modified[db] += res.ModifiedCount
+}
+
+func (c *backfillCounts) print(w io.Writer) {
+	dbs := make([]string, 0, len(c.matched))
+	for db := range c.matched {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "DATABASE\tMATCHED\tMODIFIED\n")
+	for _, db := range dbs {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", db, c.matched[db], c.modified[db])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestBackfillParse(t *testing.T) {
+	b := backfill{update: `{"$set": {"isDeleted": true}}`}.withDefaults(backfillPresets["soft-delete"])
+	if b.db != "operational-positions" || b.filter != `{"isDeleted": {"$exists": false}}` || b.update != `{"$set": {"isDeleted": true}}` {
+		t.Fatalf("unexpected preset merge %+v", b)
+	}
+
+	filter, update, err := b.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != 1 || filter[0].Key != "isDeleted" || len(update) != 1 || update[0].Key != "$set" {
+		t.Errorf("unexpected filter %v update %v", filter, update)
+	}
+
+	for _, c := range []backfill{
+		{db: "operational-positions", coll: "activity", filter: `{}`},
+		{db: "operational-positions", coll: "activity", filter: `{}`, update: `{"isDeleted": false}`},
+		{db: "operational-positions", coll: "activity", filter: `{"_id": {"$oid": "x"}}`, update: `{"$set": {"a": 1}}`},
+		{coll: "activity", filter: `{}`, update: `{"$set": {"a": 1}}`},
+	} {
+		if _, _, err := c.parse(); err == nil {
+			t.Errorf("expect an error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection 备份所在的集合名, 与台账同库
+const Collection = "backups"
+
+// TargetDB --backup/--from 取该值时备份保存在 admin 库, 否则视为本地目录
+const TargetDB = "db"
+
+// Image 文档在一次执行中被修改前的原貌
+type Image struct {
+	RunID string `bson:"runID"`
+	// Seq 同一次执行内递增, 同一文档多次修改时以最小的为准
+	Seq int64 `bson:"seq"`
+	// Conn 文档所在的连接, mongo 或 bmall
+	Conn       string      `bson:"conn"`
+	Database   string      `bson:"database"`
+	Collection string      `bson:"collection"`
+	DocID      interface{} `bson:"docID"`
+	// Existed 为 false 时表示文档由本次执行新建, 回滚时删除
+	Existed bool      `bson:"existed"`
+	Doc     bson.Raw  `bson:"doc,omitempty"`
+	At      time.Time `bson:"at"`
+}
+
+// sink 备份的落地方式
+type sink interface {
+	save(ctx context.Context, img *Image) error
+	close() error
+}
+
+// Writer 记录一次执行的修改前快照, 实现 store.Snapshotter
+type Writer struct {
+	runID string
+	seq   int64
+	conns map[*mongo.Client]string
+	sink  sink
+}
+
+// NewWriter target 为 TargetDB 时写入 admin 库的 backups 集合, 否则写入 target 目录下的 <runID>.bson
+func NewWriter(target, runID string, admin *mongo.Client, adminDB string) (*Writer, error) {
+	w := &Writer{runID: runID, conns: map[*mongo.Client]string{}}
+	if target == TargetDB {
+		w.sink = &collSink{coll: admin.Database(adminDB).Collection(Collection)}
+
+		return w, nil
+	}
+
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(archive(target, runID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w.sink = &fileSink{f: f}
+
+	return w, nil
+}
+
+// Conn 登记连接的名称, 回滚时据此选择连接
+func (w *Writer) Conn(client *mongo.Client, name string) {
+	if client != nil {
+		w.conns[client] = name
+	}
+}
+
+// Snapshot 保存文档原貌, doc 为nil表示文档此前不存在
+func (w *Writer) Snapshot(ctx context.Context, c *mongo.Collection, id interface{}, doc bson.Raw) error {
+	img := &Image{
+		RunID:      w.runID,
+		Seq:        atomic.AddInt64(&w.seq, 1),
+		Conn:       w.conns[c.Database().Client()],
+		Database:   c.Database().Name(),
+		Collection: c.Name(),
+		DocID:      id,
+		Existed:    doc != nil,
+		At:         time.Now(),
+	}
+	if doc != nil {
+		// 游标复用底层缓冲, 需拷贝
+		img.Doc = append(bson.Raw{}, doc...)
+	}
+
+	return w.sink.save(ctx, img)
+}
+
+func (w *Writer) Close() error {
+	return w.sink.close()
+}
+
+type collSink struct {
+	coll *mongo.Collection
+}
+
+func (s *collSink) save(ctx context.Context, img *Image) error {
+	_, err := s.coll.InsertOne(ctx, img)
+
+	return err
+}
+
+func (s *collSink) close() error {
+	return nil
+}
+
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (s *fileSink) save(_ context.Context, img *Image) error {
+	b, err := bson.Marshal(img)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(b)
+
+	return err
+}
+
+func (s *fileSink) close() error {
+	return s.f.Close()
+}
+
+func archive(dir, runID string) string {
+	return filepath.Join(dir, runID+".bson")
+}
+
+// Load 读取一次执行的快照, 每个文档只保留最早的一份, 按 Seq 排序
+func Load(ctx context.Context, from, runID string, admin *mongo.Client, adminDB string) ([]*Image, error) {
+	var (
+		imgs []*Image
+		err  error
+	)
+	if from == TargetDB {
+		imgs, err = loadColl(ctx, admin.Database(adminDB).Collection(Collection), runID)
+	} else {
+		imgs, err = loadFile(archive(from, runID))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(imgs, func(i, j int) bool { return imgs[i].Seq < imgs[j].Seq })
+	seen := map[string]bool{}
+	res := make([]*Image, 0, len(imgs))
+	for _, img := range imgs {
+		k := fmt.Sprintf("%s/%s/%s/%v", img.Conn, img.Database, img.Collection, img.DocID)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		res = append(res, img)
+	}
+
+	return res, nil
+}
+
+func loadColl(ctx context.Context, coll *mongo.Collection, runID string) ([]*Image, error) {
+	cur, err := coll.Find(ctx, primitive.M{"runID": runID}, options.Find().SetSort(primitive.M{"seq": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	imgs := []*Image{}
+	if err := cur.All(ctx, &imgs); err != nil {
+		return nil, err
+	}
+
+	return imgs, nil
+}
+
+func loadFile(path string) ([]*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	imgs := []*Image{}
+	for {
+		raw, err := bson.NewFromIOReader(f)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+
+		img := new(Image)
+		if err := bson.Unmarshal(raw, img); err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+
+	return imgs, nil
+}
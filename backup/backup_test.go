@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	// 仅用于取库名、集合名, 不会建立连接
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := cli.Database("camera360_qa_operational_materials").Collection("material")
+
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "run1", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Conn(cli, "mongo")
+
+	ctx := context.Background()
+	id, newID := primitive.NewObjectID(), primitive.NewObjectID()
+	first, _ := bson.Marshal(primitive.M{"_id": id, "name": "before"})
+	second, _ := bson.Marshal(primitive.M{"_id": id, "name": "after"})
+	for _, s := range []struct {
+		id  interface{}
+		doc bson.Raw
+	}{{id, first}, {id, second}, {newID, nil}} {
+		if err := w.Snapshot(ctx, coll, s.id, s.doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	imgs, err := Load(ctx, dir, "run1", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 {
+		t.Fatalf("images = %d, want 2", len(imgs))
+	}
+	if !imgs[0].Existed || imgs[0].Doc.Lookup("name").StringValue() != "before" || imgs[0].Conn != "mongo" {
+		t.Errorf("first image = %+v, want the earliest snapshot", imgs[0])
+	}
+	if imgs[1].Existed || imgs[1].DocID != newID {
+		t.Errorf("second image = %+v, want the created document", imgs[1])
+	}
+}
@@ -0,0 +1,108 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection 断点所在的集合名, 与台账同库
+const Collection = "checkpoints"
+
+// Checkpoint 一个命令在单个源集合上的同步进度
+type Checkpoint struct {
+	// ID <action>/<db>.<collection>
+	ID        string      `bson:"_id"`
+	Action    string      `bson:"action"`
+	Source    string      `bson:"source"`
+	LastID    interface{} `bson:"lastID"`
+	Done      bool        `bson:"done"`
+	RunID     string      `bson:"runID"`
+	UpdatedAt time.Time   `bson:"updatedAt"`
+}
+
+// Store 按源集合记录同步进度, nil 时所有方法均为空操作, dry-run 时只读不写
+type Store struct {
+	coll   *mongo.Collection
+	action string
+	runID  string
+	resume bool
+}
+
+func New(client *mongo.Client, db, action, runID string, resume bool) *Store {
+	return &Store{
+		coll:   client.Database(db).Collection(Collection),
+		action: action,
+		runID:  runID,
+		resume: resume,
+	}
+}
+
+func (s *Store) key(src *mongo.Collection) string {
+	return s.action + "/" + source(src)
+}
+
+func source(src *mongo.Collection) string {
+	return src.Database().Name() + "." + src.Name()
+}
+
+// Start 开始同步一个源集合, 返回应从其后继续的 _id (nil 表示从头开始)
+// 指定 --resume 且该集合上次已同步完成时 done 为 true;
+// 未指定 --resume 时清除旧的断点, 避免之后误从旧位置恢复
+func (s *Store) Start(ctx context.Context, src *mongo.Collection) (after interface{}, done bool, err error) {
+	if s == nil {
+		return nil, false, nil
+	}
+
+	if !s.resume {
+		if store.DryRun() {
+			return nil, false, nil
+		}
+		_, err := s.coll.DeleteOne(ctx, primitive.M{"_id": s.key(src)})
+
+		return nil, false, err
+	}
+
+	cp := Checkpoint{}
+	err = s.coll.FindOne(ctx, primitive.M{"_id": s.key(src)}).Decode(&cp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return cp.LastID, cp.Done, nil
+}
+
+// Save 记录源集合已处理到的最后一个 _id
+func (s *Store) Save(ctx context.Context, src *mongo.Collection, lastID interface{}) error {
+	return s.save(ctx, src, primitive.M{"lastID": lastID, "done": false})
+}
+
+// Done 标记源集合已同步完成
+func (s *Store) Done(ctx context.Context, src *mongo.Collection) error {
+	return s.save(ctx, src, primitive.M{"done": true})
+}
+
+func (s *Store) save(ctx context.Context, src *mongo.Collection, set primitive.M) error {
+	if s == nil || store.DryRun() {
+		return nil
+	}
+
+	set["action"] = s.action
+	set["source"] = source(src)
+	set["runID"] = s.runID
+	set["updatedAt"] = time.Now()
+	_, err := s.coll.UpdateOne(
+		ctx, primitive.M{"_id": s.key(src)}, primitive.M{"$set": set},
+		options.Update().SetUpsert(true),
+	)
+
+	return err
+}
@@ -3,6 +3,28 @@ package main
 import "testing"
 
 func TestExtract(t *testing.T) {
-	s, a := extractStyleAndAttribue("{\"style\":{\"opacity\":1},\"attribute\":{\"zIndex\":0,\"left\":0,\"borderRadius\":10}}")
+	s, a, problems := extractStyleAndAttribue("{\"style\":{\"opacity\":1},\"attribute\":{\"zIndex\":0,\"left\":0,\"borderRadius\":10}}")
+	if len(problems) > 0 {
+		t.Fatalf("problems = %v", problems)
+	}
 	t.Log(s, a)
+
+	s, a, problems = extractStyleAndAttribue("{\"style\":{\"opacity\":\"1\",\"blur\":2},\"attribute\":[]}")
+	got := map[string]bool{}
+	for _, p := range problems {
+		got[p.Path] = true
+	}
+	if len(got) != 3 || !got["style.opacity"] || !got["style.blur"] || !got["attribute"] {
+		t.Errorf("problems = %v, want style.opacity, style.blur and attribute", problems)
+	}
+	if countInvalid(problems) != 2 {
+		t.Errorf("problems = %v, want only style.blur to be an unknown key", problems)
+	}
+	if s == "" || a != "[]" {
+		t.Errorf("style = %q, attribute = %q, want them kept", s, a)
+	}
+
+	if s, _, problems = extractStyleAndAttribue("{\"style\":"); s != "" || len(problems) != 1 {
+		t.Errorf("style = %q, problems = %v, want a malformed problem", s, problems)
+	}
 }
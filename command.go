@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/ledger"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// connection 命令执行前需要建立的连接
+type connection uint8
+
+const (
+	connMongo connection = 1 << iota
+	connBmall
+)
+
+func (c connection) String() string {
+	names := []string{}
+	if c&connMongo != 0 {
+		names = append(names, "mongo")
+	}
+	if c&connBmall != 0 {
+		names = append(names, "bmall-mongo")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// runtime 命令运行时依赖的连接与全局参数
+type runtime struct {
+	opt    *option
+	cfg    *config.Config
+	action string
+	mongo  *mongo.Client
+	bmall  *mongo.Client
+	// run 本次执行在台账中的记录
+	run *ledger.Run
+	// report 本次执行失败文档的报告
+	report *report.Report
+	// ids --include-ids-file/--exclude-ids-file 加载的ID列表, 仅 withIDFiles 包装的命令有值
+	ids *idlist.Filter
+
+	mu sync.Mutex
+	// results 各执行单元的结果, 用于按库记录台账
+	results []executor.Result
+}
+
+// addResults 记录 material.Options.Run 的执行结果
+func (rt *runtime) addResults(results []executor.Result) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.results = append(rt.results, results...)
+}
+
+// targets 返回本次执行的目标 scope/env, 命令行参数优先于配置文件
+func (rt *runtime) targets() (config.Matrix, error) {
+	return rt.cfg.Matrix(rt.action).Override(config.SplitList(rt.opt.Scope), config.SplitList(rt.opt.Env))
+}
+
+// mqProfile 返回 --mq-profile 选择的 kafka 配置, 未指定时返回nil
+func (rt *runtime) mqProfile() (*config.MQProfile, error) {
+	if rt.opt.MQProfile == "" {
+		return nil, nil
+	}
+
+	return rt.cfg.MQProfile(rt.opt.MQProfile)
+}
+
+// command 一个可通过命令行执行的动作
+type command struct {
+	name  string
+	desc  string
+	conns connection
+	// writes 命令在每个目标空间中写入的库(不含 <scope>_<env>_ 前缀), 用于记录台账
+	writes []string
+	// sourceEnvs 只作为数据源、不写入的 env, 如以 operation 为源的命令, 不记录台账
+	sourceEnvs []string
+	// targets 写入的库随参数变化时, 在参数解析后返回 writes 及 sourceEnvs, 可为空
+	targets func() (writes, sourceEnvs []string)
+	// oneShot 一次性迁移, 已在某库成功执行过的不允许重复执行, 除非指定 --force
+	oneShot bool
+	// version 命令逻辑变更后递增, 一次性迁移按版本判断是否执行过, 默认 1
+	version string
+	// idChoice 原先只处理代码中写死的ID, 需显式指定 --include-ids-file 或 --all, 仅对 withIDFiles 包装的命令生效
+	idChoice bool
+	// setFlags 注册该命令自己的参数,可为空
+	setFlags func(fs *flag.FlagSet)
+	run      func(ctx context.Context, rt *runtime) error
+}
+
+var commands = map[string]*command{}
+
+// register 注册命令,命令名重复时直接panic,避免覆盖
+func register(c *command) {
+	if c.name == "" || c.run == nil {
+		panic("register command without name or run func")
+	}
+	if _, ok := commands[c.name]; ok {
+		panic("command " + c.name + " registered twice")
+	}
+
+	commands[c.name] = c
+}
+
+func sortedCommands() []*command {
+	res := make([]*command, 0, len(commands))
+	for _, c := range commands {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+
+	return res
+}
+
+// lookup 查找命令,找不到时返回相近的命令名作为提示
+func lookup(name string) (*command, error) {
+	if c, ok := commands[name]; ok {
+		return c, nil
+	}
+
+	msg := fmt.Sprintf("unknown action %q", name)
+	if s := suggest(name); len(s) > 0 {
+		msg += ", did you mean: " + strings.Join(s, ", ") + "?"
+	}
+
+	return nil, errors.New(msg + " (run `mongodbcli list` to see all actions)")
+}
+
+// suggest 按编辑距离及包含关系给出最多三个候选命令
+func suggest(name string) []string {
+	type candidate struct {
+		name string
+		dist int
+	}
+
+	lower := strings.ToLower(name)
+	cands := []candidate{}
+	for n := range commands {
+		ln := strings.ToLower(n)
+		d := levenshtein(lower, ln)
+		contains := lower != "" && (strings.Contains(ln, lower) || strings.Contains(lower, ln))
+		if contains || d <= len(n)/4+1 {
+			cands = append(cands, candidate{name: n, dist: d})
+		}
+	}
+
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].dist != cands[j].dist {
+			return cands[i].dist < cands[j].dist
+		}
+		return cands[i].name < cands[j].name
+	})
+
+	res := []string{}
+	for i := 0; i < len(cands) && i < 3; i++ {
+		res = append(res, cands[i].name)
+	}
+
+	return res
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+func (c *command) ver() string {
+	if c.version == "" {
+		return "1"
+	}
+
+	return c.version
+}
+
+// targetDBs 返回命令在目标空间中将写入的库, sourceEnvs 中的 env 不写入
+func (c *command) targetDBs(m config.Matrix) []string {
+	writes, sourceEnvs := c.writes, c.sourceEnvs
+	if c.targets != nil {
+		writes, sourceEnvs = c.targets()
+	}
+
+	dbs := []string{}
+	for _, sp := range m.Without(sourceEnvs...).Spaces() {
+		for _, db := range writes {
+			dbs = append(dbs, sp.DBName(db))
+		}
+	}
+
+	return dbs
+}
+
+// flagSet 构建命令自己的参数集合
+func (c *command) flagSet(out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	if c.setFlags != nil {
+		c.setFlags(fs)
+	}
+	fs.Usage = func() { c.usage(out, fs) }
+
+	return fs
+}
+
+func (c *command) usage(out io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(out, "Usage: mongodbcli [global flags] %s [flags]\n\n", c.name)
+	fmt.Fprintf(out, "%s\n\n", c.desc)
+	fmt.Fprintf(out, "Connections: %s\n", c.conns)
+	if c.oneShot {
+		fmt.Fprintf(out, "One-shot: yes, version %s (use --force to re-run on completed databases)\n", c.ver())
+	}
+
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(out, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+}
+
+// printList 输出全部命令及其说明
+func printList(out io.Writer) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.desc)
+	}
+	tw.Flush()
+}
+
+func printUsage(out io.Writer, global *flag.FlagSet) {
+	fmt.Fprintf(out, "Usage: mongodbcli [global flags] <action> [action flags]\n\n")
+	fmt.Fprintf(out, "Actions:\n")
+	printList(out)
+	fmt.Fprintf(out, "\nRun `mongodbcli help <action>` or `mongodbcli <action> --help` for action details.\n")
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	global.SetOutput(out)
+	global.PrintDefaults()
+}
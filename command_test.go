@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+)
+
+func TestSuggest(t *testing.T) {
+	cases := map[string][]string{
+		"syncMaterial":  {"syncMaterials", "syncMaterialsPlan", "syncMaterialCategorys"},
+		"synch5style":   {"syncH5Style"},
+		"resetH5Actve":  {"resetH5Active"},
+		"xxxxxxxxxxxxx": {},
+	}
+
+	for name, want := range cases {
+		if got := suggest(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("suggest(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if _, err := lookup("syncH5Style"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lookup("syncH5Styl"); err == nil {
+		t.Fatal("expect unknown action error")
+	}
+}
+
+func TestIDChoice(t *testing.T) {
+	newCommand := func(args ...string) (*command, *bool) {
+		ran := false
+		c := withIDFiles(&command{name: "x", idChoice: true, run: func(context.Context, *runtime) error {
+			ran = true
+
+			return nil
+		}})
+
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+
+		return c, &ran
+	}
+
+	c, ran := newCommand()
+	if err := c.run(context.Background(), &runtime{}); err == nil || *ran {
+		t.Errorf("expect an error without --include-ids-file or --all, got %v", err)
+	}
+
+	c, ran = newCommand("--all", "--include-ids-file=material-include-ids.csv")
+	if err := c.run(context.Background(), &runtime{}); err == nil || *ran {
+		t.Errorf("expect an error with both --all and --include-ids-file, got %v", err)
+	}
+
+	c, ran = newCommand("--all")
+	if err := c.run(context.Background(), &runtime{}); err != nil || !*ran {
+		t.Errorf("expect --all to run the command, got %v", err)
+	}
+}
+
+func TestTargetDBs(t *testing.T) {
+	c, err := lookup("resetH5StyleMainID")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.targetDBs(config.Matrix{"camera360": {"operation", "prod"}})
+	if !reflect.DeepEqual(got, []string{"camera360_prod_h5"}) {
+		t.Errorf("targetDBs = %v, want the operation env skipped", got)
+	}
+
+	c = &command{
+		writes: []string{"operational_materials", "h5"},
+		targets: func() (writes, sourceEnvs []string) {
+			return []string{"h5"}, []string{"operation"}
+		},
+	}
+	got = c.targetDBs(config.Matrix{"camera360": {"operation", "prod"}})
+	if !reflect.DeepEqual(got, []string{"camera360_prod_h5"}) {
+		t.Errorf("targetDBs = %v, want the dbs returned by targets", got)
+	}
+}
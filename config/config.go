@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Matrix 产品空间(scope)与环境(env)的组合, 如 camera360 => [prod, dev]
+type Matrix map[string][]string
+
+// Space 一个 scope_env 空间, 库名为 <scope>_<env>_<db>
+type Space struct {
+	Scope string
+	Env   string
+}
+
+func (s Space) String() string {
+	return s.Scope + "_" + s.Env
+}
+
+// DBName 返回该空间下指定后缀的库名
+func (s Space) DBName(db string) string {
+	return fmt.Sprintf("%s_%s_%s", s.Scope, s.Env, db)
+}
+
+// Spaces 按 scope 名称排序展开全部空间, env 保持配置中的顺序
+func (m Matrix) Spaces() []Space {
+	scopes := make([]string, 0, len(m))
+	for sp := range m {
+		scopes = append(scopes, sp)
+	}
+	sort.Strings(scopes)
+
+	res := []Space{}
+	for _, sp := range scopes {
+		for _, env := range m[sp] {
+			res = append(res, Space{Scope: sp, Env: env})
+		}
+	}
+
+	return res
+}
+
+// Without 去掉指定的 env, 常用于以 operation 为源同步到其它环境
+func (m Matrix) Without(envs ...string) Matrix {
+	res := Matrix{}
+	for sp, es := range m {
+		for _, e := range es {
+			if !contains(envs, e) {
+				res[sp] = append(res[sp], e)
+			}
+		}
+	}
+
+	return res
+}
+
+// Override 使用命令行指定的 scope/env 覆盖配置:
+// 指定了 scope 时只保留这些 scope, 指定了 env 时所有 scope 都使用这些 env
+func (m Matrix) Override(scopes, envs []string) (Matrix, error) {
+	if len(scopes) == 0 {
+		for sp := range m {
+			scopes = append(scopes, sp)
+		}
+	}
+
+	res := Matrix{}
+	for _, sp := range scopes {
+		es := envs
+		if len(es) == 0 {
+			es = m[sp]
+		}
+		if len(es) == 0 {
+			return nil, fmt.Errorf("no env configured for scope %s, please set --env", sp)
+		}
+
+		res[sp] = append([]string{}, es...)
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("no target scope, please set --config or --scope and --env")
+	}
+
+	return res, nil
+}
+
+// Config 命令行配置文件
+type Config struct {
+	// Scopes 默认的目标 scope/env
+	Scopes Matrix `yaml:"scopes" json:"scopes"`
+	// Actions 按命令名覆盖默认的目标 scope/env
+	Actions map[string]Matrix `yaml:"actions" json:"actions"`
+	// MQ 按名称配置的 kafka 连接, 通过 --mq-profile 选择
+	MQ map[string]*MQProfile `yaml:"mq" json:"mq"`
+}
+
+// Load 加载 yaml 或 json(以 .json 结尾)配置文件
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Config)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, c)
+	} else {
+		err = yaml.Unmarshal(b, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Matrix 返回指定命令的目标 scope/env, 未单独配置时使用默认值
+func (c *Config) Matrix(action string) Matrix {
+	if c == nil {
+		return Matrix{}
+	}
+
+	if m, ok := c.Actions[action]; ok {
+		return m
+	}
+
+	return c.Scopes
+}
+
+// SplitList 解析逗号分隔的参数
+func SplitList(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
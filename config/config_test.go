@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.yaml")
+	content := `
+scopes: &all
+  camera360: [prod, dev]
+  icc: [qa]
+actions:
+  syncH5Style:
+    camera360: [operation]
+  resetH5Type: *all
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Space{{"camera360", "prod"}, {"camera360", "dev"}, {"icc", "qa"}}
+	if got := c.Matrix("resetH5Type").Spaces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("spaces = %v, want %v", got, want)
+	}
+
+	if got := c.Matrix("syncH5Style").Spaces(); !reflect.DeepEqual(got, []Space{{"camera360", "operation"}}) {
+		t.Errorf("action override = %v", got)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	m := Matrix{"camera360": {"prod", "dev"}, "icc": {"qa"}}
+
+	got, err := m.Override([]string{"icc"}, nil)
+	if err != nil || !reflect.DeepEqual(got, Matrix{"icc": {"qa"}}) {
+		t.Errorf("override scope = %v, %v", got, err)
+	}
+
+	got, err = m.Override(nil, []string{"pre"})
+	if err != nil || !reflect.DeepEqual(got, Matrix{"camera360": {"pre"}, "icc": {"pre"}}) {
+		t.Errorf("override env = %v, %v", got, err)
+	}
+
+	if _, err = m.Override([]string{"mix"}, nil); err == nil {
+		t.Error("expect error for scope without env")
+	}
+
+	if got := m.Without("dev"); !reflect.DeepEqual(got, Matrix{"camera360": {"prod"}, "icc": {"qa"}}) {
+		t.Errorf("without = %v", got)
+	}
+}
+
+func TestMQProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.yaml")
+	content := `
+mq:
+  qa:
+    brokers: [127.0.0.1:9092]
+  prod:
+    brokers: [127.0.0.1:9093]
+    sasl:
+      username: u
+      password_env: TEST_MQ_PASSWORD
+  bad:
+    brokers: [127.0.0.1:9093]
+    sasl:
+      mechanism: SCRAM-SHA-512
+      username: u
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := c.MQProfile("qa"); err != nil || p.SASL != nil || p.Name != "qa" {
+		t.Errorf("qa = %+v, %v", p, err)
+	}
+
+	p, err := c.MQProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_MQ_PASSWORD", "secret")
+	if pwd, err := p.SASL.Password(); err != nil || pwd != "secret" {
+		t.Errorf("password = %q, %v", pwd, err)
+	}
+
+	if _, err := c.MQProfile("bad"); err == nil {
+		t.Error("expect error for unsupported mechanism")
+	}
+	if _, err := c.MQProfile("missing"); err == nil {
+		t.Error("expect error for unknown profile")
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MQProfile 一套 kafka 连接配置, 密码只能来自环境变量或文件, 不写在配置中
+type MQProfile struct {
+	Name    string   `yaml:"-" json:"-"`
+	Brokers []string `yaml:"brokers" json:"brokers"`
+	// SASL 配置了账号时 go-base 的 kafka sender 使用 TLS 连接(如阿里云 9093 端口), 不能单独开启 TLS
+	SASL *SASL `yaml:"sasl" json:"sasl"`
+}
+
+// SASL kafka 认证配置
+type SASL struct {
+	// Mechanism 目前仅支持 PLAIN, 为空时等同 PLAIN
+	Mechanism    string `yaml:"mechanism" json:"mechanism"`
+	Username     string `yaml:"username" json:"username"`
+	PasswordEnv  string `yaml:"password_env" json:"password_env"`
+	PasswordFile string `yaml:"password_file" json:"password_file"`
+}
+
+// Password 读取密码, 环境变量优先于文件
+func (s *SASL) Password() (string, error) {
+	if s.PasswordEnv != "" {
+		if v := os.Getenv(s.PasswordEnv); v != "" {
+			return v, nil
+		}
+	}
+
+	if s.PasswordFile != "" {
+		b, err := os.ReadFile(s.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("read kafka password: %w", err)
+		}
+
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	if s.PasswordEnv != "" {
+		return "", fmt.Errorf("kafka password env %s is empty", s.PasswordEnv)
+	}
+
+	return "", errors.New("kafka sasl needs password_env or password_file")
+}
+
+// Validate 校验配置是否能被 kafka sender 使用
+func (p *MQProfile) Validate() error {
+	if len(p.Brokers) == 0 {
+		return fmt.Errorf("mq profile %s has no brokers", p.Name)
+	}
+
+	if p.SASL == nil {
+		return nil
+	}
+
+	if m := strings.ToUpper(p.SASL.Mechanism); m != "" && m != "PLAIN" {
+		return fmt.Errorf("mq profile %s: sasl mechanism %s is not supported, only PLAIN", p.Name, p.SASL.Mechanism)
+	}
+	if p.SASL.Username == "" {
+		return fmt.Errorf("mq profile %s: sasl username is empty", p.Name)
+	}
+
+	return nil
+}
+
+// MQProfile 按名称返回 kafka 配置
+func (c *Config) MQProfile(name string) (*MQProfile, error) {
+	var p *MQProfile
+	if c != nil {
+		p = c.MQ[name]
+	}
+	if p == nil {
+		return nil, fmt.Errorf("unknown mq profile %q, configured: %s", name, strings.Join(c.mqNames(), ", "))
+	}
+
+	p.Name = name
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (c *Config) mqNames() []string {
+	if c == nil || len(c.MQ) == 0 {
+		return []string{"none"}
+	}
+
+	names := make([]string, 0, len(c.MQ))
+	for n := range c.MQ {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/diff"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// diffKind 可比较的数据, 库名为 <scope>_<env>_<db>
+type diffKind struct {
+	db, coll string
+	// key 跨空间匹配文档的字段
+	key string
+	// ignore 默认不比较的字段
+	ignore []string
+}
+
+var diffKinds = map[string]diffKind{
+	"material": {db: "operational_materials", coll: "material", key: "_id"},
+	"category": {db: "operational_materials", coll: "material_category", key: "_id"},
+	"plan":     {db: "operational_materials", coll: "plan", key: "_id"},
+	"position": {db: "operational_materials", coll: "materialPosition", key: "_id"},
+	// h5 样式各空间的 _id 不同, 以 activityID 匹配
+	"h5props": {db: "h5", coll: "properties", key: "activityID", ignore: []string{"_id"}},
+}
+
+func diffKindNames() []string {
+	names := make([]string, 0, len(diffKinds))
+	for k := range diffKinds {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	var (
+		from, to, kind, format, ignore string
+	)
+
+	register(&command{
+		name:  "diff",
+		desc:  "compare the documents of two scope/env spaces field by field, read only",
+		conns: connMongo,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&from, "from", "", "the source space <scope>_<env>, e.g. camera360_operation")
+			fs.StringVar(&to, "to", "", "the target space <scope>_<env>, e.g. camera360_prod")
+			fs.StringVar(&kind, "kind", "", "what to compare: "+strings.Join(diffKindNames(), ", "))
+			fs.StringVar(&format, "format", "text", "output format: text or json")
+			fs.StringVar(&ignore, "ignore", "", "comma separated fields not compared, array indexes may be omitted, e.g. versions.updatedAt")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			if from == "" || to == "" {
+				return errors.New("please set --from and --to")
+			}
+			k, ok := diffKinds[kind]
+			if !ok {
+				return fmt.Errorf("unknown --kind %q, use one of %s", kind, strings.Join(diffKindNames(), ", "))
+			}
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown --format %q, use text or json", format)
+			}
+
+			return diffSpaces(ctx, rt.mongo, from, to, k, format, config.SplitList(ignore))
+		},
+	})
+}
+
+// diffSpaces 比较两个空间中同一类数据
+func diffSpaces(ctx context.Context, client *mongo.Client, from, to string, k diffKind, format string, ignore []string) error {
+	fromDB, toDB := from+"_"+k.db, to+"_"+k.db
+	if err := checkDatabases(ctx, client, fromDB, toDB); err != nil {
+		return err
+	}
+
+	res, err := diff.Collections(
+		ctx, client.Database(fromDB).Collection(k.coll), client.Database(toDB).Collection(k.coll),
+		k.key, append(ignore, k.ignore...),
+	)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return res.PrintJSON(os.Stdout)
+	}
+	res.Print(os.Stdout)
+
+	return nil
+}
+
+// checkDatabases 库不存在时报错, 避免拼错空间名时输出全部文档为新增或删除
+func checkDatabases(ctx context.Context, client *mongo.Client, dbs ...string) error {
+	names, err := client.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$in": dbs}})
+	if err != nil {
+		return err
+	}
+
+	exists := map[string]bool{}
+	for _, n := range names {
+		exists[n] = true
+	}
+	for _, db := range dbs {
+		if !exists[db] {
+			return fmt.Errorf("database %s does not exist", db)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Op 文档的变化类型
+type Op string
+
+const (
+	OpAdded   Op = "added"   // 仅存在于 to
+	OpRemoved Op = "removed" // 仅存在于 from
+	OpChanged Op = "changed"
+)
+
+// Field 一个字段的差异, 不存在的一侧为空
+type Field struct {
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Change 一个文档的差异
+type Change struct {
+	Key    string  `json:"key"`
+	Op     Op      `json:"op"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Result 两个集合的比较结果
+type Result struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Key     string   `json:"key"`
+	Same    int      `json:"same"`
+	Changes []Change `json:"changes"`
+}
+
+// Count 返回指定类型的文档数
+func (r *Result) Count(op Op) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Op == op {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Collections 按 key 字段匹配两个集合中的文档并逐字段比较, 见 Docs
+// to 一侧的文档会全部加载到内存
+func Collections(ctx context.Context, from, to *mongo.Collection, key string, ignore []string) (*Result, error) {
+	res := &Result{From: fullName(from), To: fullName(to), Key: key}
+
+	targets := map[string]bson.Raw{}
+	if err := each(ctx, to, key, func(k string, doc bson.Raw) {
+		targets[k] = doc
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := each(ctx, from, key, func(k string, doc bson.Raw) {
+		t, ok := targets[k]
+		if !ok {
+			res.Changes = append(res.Changes, Change{Key: k, Op: OpRemoved})
+
+			return
+		}
+		delete(targets, k)
+
+		fields := Docs(doc, t, ignore)
+		if len(fields) == 0 {
+			res.Same++
+
+			return
+		}
+		res.Changes = append(res.Changes, Change{Key: k, Op: OpChanged, Fields: fields})
+	}); err != nil {
+		return nil, err
+	}
+
+	for k := range targets {
+		res.Changes = append(res.Changes, Change{Key: k, Op: OpAdded})
+	}
+	sort.Slice(res.Changes, func(i, j int) bool {
+		return res.Changes[i].Key < res.Changes[j].Key
+	})
+
+	return res, nil
+}
+
+func fullName(c *mongo.Collection) string {
+	return c.Database().Name() + "." + c.Name()
+}
+
+// each 遍历集合, 没有 key 字段的文档跳过
+func each(ctx context.Context, c *mongo.Collection, key string, fn func(k string, doc bson.Raw)) error {
+	cur, err := c.Find(ctx, bson.M{key: bson.M{"$exists": true}})
+	if err != nil {
+		return fmt.Errorf("find %s: %w", fullName(c), err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		doc := make(bson.Raw, len(cur.Current))
+		copy(doc, cur.Current)
+		fn(keyString(doc.Lookup(key)), doc)
+	}
+
+	return cur.Err()
+}
+
+func keyString(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.ObjectID:
+		return v.ObjectID().Hex()
+	case bsontype.String:
+		return v.StringValue()
+	}
+
+	return v.String()
+}
+
+// Docs 逐字段比较两个文档, 嵌套文档按字段名、数组按下标展开为点分路径
+// ignore 中的字段(含子字段)不参与比较, 路径可省略数组下标, 如 versions.updatedAt
+func Docs(from, to bson.Raw, ignore []string) []Field {
+	skip := map[string]bool{}
+	for _, f := range ignore {
+		skip[f] = true
+	}
+
+	res := []Field{}
+	compareDocs("", from, to, skip, &res)
+
+	return res
+}
+
+// ignored 完整路径或去掉数组下标后的路径在 ignore 中
+func ignored(path string, ignore map[string]bool) bool {
+	if ignore[path] {
+		return true
+	}
+
+	segs := strings.Split(path, ".")
+	named := segs[:0]
+	for _, s := range segs {
+		if _, err := strconv.Atoi(s); err != nil {
+			named = append(named, s)
+		}
+	}
+
+	return ignore[strings.Join(named, ".")]
+}
+
+func compareDocs(prefix string, from, to bson.Raw, ignore map[string]bool, res *[]Field) {
+	fromElems, _ := from.Elements()
+	toElems, _ := to.Elements()
+
+	toVals := make(map[string]bson.RawValue, len(toElems))
+	for _, e := range toElems {
+		toVals[e.Key()] = e.Value()
+	}
+
+	seen := map[string]bool{}
+	for _, e := range fromElems {
+		k := e.Key()
+		seen[k] = true
+		t, ok := toVals[k]
+		if !ok {
+			addField(prefix+k, e.Value(), bson.RawValue{}, ignore, res)
+
+			continue
+		}
+		compareValues(prefix+k, e.Value(), t, ignore, res)
+	}
+
+	for _, e := range toElems {
+		if !seen[e.Key()] {
+			addField(prefix+e.Key(), bson.RawValue{}, e.Value(), ignore, res)
+		}
+	}
+}
+
+func compareValues(path string, from, to bson.RawValue, ignore map[string]bool, res *[]Field) {
+	if ignored(path, ignore) {
+		return
+	}
+
+	switch {
+	case from.Type == bsontype.EmbeddedDocument && to.Type == bsontype.EmbeddedDocument:
+		compareDocs(path+".", from.Document(), to.Document(), ignore, res)
+	case from.Type == bsontype.Array && to.Type == bsontype.Array:
+		fromVals, _ := from.Array().Values()
+		toVals, _ := to.Array().Values()
+		for i := 0; i < len(fromVals) || i < len(toVals); i++ {
+			p := path + "." + strconv.Itoa(i)
+			switch {
+			case i >= len(toVals):
+				addField(p, fromVals[i], bson.RawValue{}, ignore, res)
+			case i >= len(fromVals):
+				addField(p, bson.RawValue{}, toVals[i], ignore, res)
+			default:
+				compareValues(p, fromVals[i], toVals[i], ignore, res)
+			}
+		}
+	default:
+		if !from.Equal(to) {
+			addField(path, from, to, ignore, res)
+		}
+	}
+}
+
+func addField(path string, from, to bson.RawValue, ignore map[string]bool, res *[]Field) {
+	if ignored(path, ignore) {
+		return
+	}
+
+	*res = append(*res, Field{Path: path, From: valueString(from), To: valueString(to)})
+}
+
+func valueString(v bson.RawValue) string {
+	if v.Type == 0 {
+		return ""
+	}
+
+	return v.String()
+}
+
+// Print 以文本输出, + 仅存在于 to, - 仅存在于 from, ~ 有差异
+func (r *Result) Print(w io.Writer) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", r.From, r.To)
+	for _, c := range r.Changes {
+		switch c.Op {
+		case OpAdded:
+			fmt.Fprintf(w, "+ %s\n", c.Key)
+		case OpRemoved:
+			fmt.Fprintf(w, "- %s\n", c.Key)
+		case OpChanged:
+			fmt.Fprintf(w, "~ %s\n", c.Key)
+			for _, f := range c.Fields {
+				switch {
+				case f.From == "":
+					fmt.Fprintf(w, "    + %s: %s\n", f.Path, f.To)
+				case f.To == "":
+					fmt.Fprintf(w, "    - %s: %s\n", f.Path, f.From)
+				default:
+					fmt.Fprintf(w, "    ~ %s: %s => %s\n", f.Path, f.From, f.To)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed, %d same\n",
+		r.Count(OpAdded), r.Count(OpRemoved), r.Count(OpChanged), r.Same)
+}
+
+// PrintJSON 以 JSON 输出
+func (r *Result) PrintJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDocs(t *testing.T) {
+	from, _ := bson.Marshal(bson.D{
+		{Key: "name", Value: "a"},
+		{Key: "versions", Value: bson.A{
+			bson.D{{Key: "name", Value: "v1"}, {Key: "updatedAt", Value: 1}},
+			bson.D{{Key: "name", Value: "v2"}},
+		}},
+		{Key: "removed", Value: true},
+	})
+	to, _ := bson.Marshal(bson.D{
+		{Key: "versions", Value: bson.A{
+			bson.D{{Key: "name", Value: "v1"}, {Key: "updatedAt", Value: 2}},
+		}},
+		{Key: "name", Value: "b"},
+		{Key: "added", Value: 1},
+	})
+
+	got := map[string]Field{}
+	for _, f := range Docs(from, to, []string{"versions.updatedAt"}) {
+		got[f.Path] = f
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("fields = %+v, want 4", got)
+	}
+	if f := got["name"]; f.From != `"a"` || f.To != `"b"` {
+		t.Errorf("name = %+v", f)
+	}
+	if f, ok := got["versions.1"]; !ok || f.To != "" {
+		t.Errorf("versions.1 = %+v, want removed", f)
+	}
+	if f, ok := got["removed"]; !ok || f.To != "" {
+		t.Errorf("removed = %+v", f)
+	}
+	if f, ok := got["added"]; !ok || f.From != "" {
+		t.Errorf("added = %+v", f)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/indexes"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	var spec string
+
+	register(&command{
+		name:  "ensure-indexes",
+		desc:  "compare the indexes of every target collection with an index spec file, report the differences and create the missing ones",
+		conns: connMongo,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&spec, "spec", "indexes/indexes.yaml", "yaml or json index spec file")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			s, err := indexes.Load(spec)
+			if err != nil {
+				return err
+			}
+
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			res := &indexes.Result{}
+			units := []executor.Unit{}
+			spaces := map[string]config.Space{}
+			for db, colls := range s.Collections() {
+				db := db
+				for _, sp := range opt.Matrix.Spaces() {
+					spaces[sp.DBName(db)] = sp
+				}
+
+				e := &dbEntity{dbName: db, coll: colls}
+				units = append(units, e.units(rt.mongo, opt.Matrix, func(ctx context.Context, coll *mongo.Collection) error {
+					return indexes.Ensure(ctx, coll, s.For(db, coll.Name()), res)
+				})...)
+			}
+			runErr := opt.Run(ctx, units)
+			res.Print(os.Stdout)
+
+			failed := 0
+			for _, row := range res.Rows {
+				if row.Err == nil && row.Diff.Status != indexes.StatusDifferent {
+					continue
+				}
+
+				coll := rt.mongo.Database(row.Database).Collection(row.Collection)
+				rec := report.Record{Kind: report.KindIndex, Class: report.ClassConflict}
+				rec.Scope, rec.Env = spaces[row.Database].Scope, spaces[row.Database].Env
+				err := fmt.Errorf("%s: %s", row.Diff.Status, row.Diff.Reason)
+				if row.Err != nil {
+					failed++
+					rec.Class, err = "", row.Err
+				}
+				rt.report.Fail(coll, row.Diff.Name, rec, err)
+			}
+			if runErr == nil && failed > 0 {
+				runErr = fmt.Errorf("%d indexes could not be created", failed)
+			}
+
+			return runErr
+		},
+	})
+}
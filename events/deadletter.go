@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeadLetterCollection 发送失败的事件所在的集合名, 与台账同库
+const DeadLetterCollection = "dead_letters"
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+)
+
+// DeadLetter 一条发送失败的事件
+type DeadLetter struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	RunID   string             `bson:"runID"`
+	Action  string             `bson:"action"`
+	Topic   string             `bson:"topic"`
+	Key     string             `bson:"key"`
+	Headers map[string]string  `bson:"headers"`
+	TrackID string             `bson:"trackID"`
+	// Payload 保存为字符串, 便于直接在库中查看
+	Payload   string    `bson:"payload"`
+	Error     string    `bson:"error"`
+	Attempts  int       `bson:"attempts"`
+	Status    string    `bson:"status"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// Envelope 还原为待发送的事件
+func (d *DeadLetter) Envelope() *Envelope {
+	return &Envelope{
+		Topic:   d.Topic,
+		Key:     d.Key,
+		Headers: d.Headers,
+		TrackID: d.TrackID,
+		Payload: json.RawMessage(d.Payload),
+	}
+}
+
+// DeadLetters 死信存储
+type DeadLetters struct {
+	coll   *mongo.Collection
+	runID  string
+	action string
+}
+
+func NewDeadLetters(client *mongo.Client, db, runID, action string) *DeadLetters {
+	return &DeadLetters{
+		coll:   client.Database(db).Collection(DeadLetterCollection),
+		runID:  runID,
+		action: action,
+	}
+}
+
+// Wrap 发送失败时将整批事件连同错误写入死信, 错误仍返回给调用方
+func (d *DeadLetters) Wrap(p Publisher) Publisher {
+	return &deadLetterPublisher{next: p, dl: d}
+}
+
+type deadLetterPublisher struct {
+	next Publisher
+	dl   *DeadLetters
+}
+
+func (p *deadLetterPublisher) Publish(ctx context.Context, envs ...*Envelope) error {
+	err := p.next.Publish(ctx, envs...)
+	if err == nil {
+		return nil
+	}
+
+	if serr := p.dl.Save(ctx, envs, err); serr != nil {
+		return fmt.Errorf("%w, and saving them to dead letters failed: %v", err, serr)
+	}
+
+	return fmt.Errorf("%w, %d events saved to dead letters of run %s", err, len(envs), p.dl.runID)
+}
+
+// Save 保存发送失败的事件
+func (d *DeadLetters) Save(ctx context.Context, envs []*Envelope, sendErr error) error {
+	now := time.Now()
+	docs := make([]interface{}, 0, len(envs))
+	for _, e := range envs {
+		docs = append(docs, &DeadLetter{
+			RunID:     d.runID,
+			Action:    d.action,
+			Topic:     e.Topic,
+			Key:       e.Key,
+			Headers:   e.Headers,
+			TrackID:   e.TrackID,
+			Payload:   string(e.Payload),
+			Error:     sendErr.Error(),
+			Attempts:  1,
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	_, err := d.coll.InsertMany(ctx, docs)
+
+	return err
+}
+
+// Pending 返回未送达的死信, runID 为空时返回全部
+func (d *DeadLetters) Pending(ctx context.Context, runID string) ([]*DeadLetter, error) {
+	filter := primitive.M{"status": StatusPending}
+	if runID != "" {
+		filter["runID"] = runID
+	}
+
+	cur, err := d.coll.Find(ctx, filter, options.Find().SetSort(primitive.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*DeadLetter{}
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Result 记录一次重发的结果, sendErr 为nil表示已送达
+func (d *DeadLetters) Result(ctx context.Context, l *DeadLetter, attempts int, sendErr error) error {
+	set := primitive.M{"updatedAt": time.Now(), "status": StatusDelivered}
+	if sendErr != nil {
+		set["status"] = StatusPending
+		set["error"] = sendErr.Error()
+	}
+
+	_, err := d.coll.UpdateByID(ctx, l.ID, primitive.M{"$set": set, "$inc": primitive.M{"attempts": attempts}})
+
+	return err
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pinguo-icc/go-base/v2/event"
+	"github.com/pinguo-icc/mongodbcli/config"
+)
+
+// Envelope 一条待发送的事件, 字段与 event.NewEvent 的参数一一对应
+type Envelope struct {
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key"`
+	Headers map[string]string `json:"headers"`
+	TrackID string            `json:"trackID"`
+	// Payload protojson 序列化后的事件内容
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Event 转为 go-base 的事件
+func (e *Envelope) Event() event.Event {
+	return event.NewEvent(
+		[]byte(e.Payload),
+		e.Key,
+		event.WithHeaders(e.Headers),
+		event.WithTopic(e.Topic),
+		event.WithHeaderTrackID(e.TrackID),
+	)
+}
+
+// Publisher 事件的发送方式
+type Publisher interface {
+	Publish(ctx context.Context, envs ...*Envelope) error
+}
+
+// Kafka 通过 go-base 的 kafka sender 发送
+type Kafka struct {
+	sender event.Sender
+}
+
+// NewKafka 按 profile 连接 kafka
+func NewKafka(p *config.MQProfile) (*Kafka, func(), error) {
+	if p == nil {
+		return nil, nil, errors.New("the action sends kafka events, please set --mq-profile or --events-file")
+	}
+
+	base := event.BaseConfig{}
+	if p.SASL != nil {
+		pwd, err := p.SASL.Password()
+		if err != nil {
+			return nil, nil, fmt.Errorf("mq profile %s: %w", p.Name, err)
+		}
+		base.UserName, base.Password = p.SASL.Username, pwd
+	}
+
+	sender, cancel := event.NewKafkaSender(&event.Config{
+		Base: base,
+		Write: event.WriteConfig{
+			Addr:            p.Brokers,
+			BatchSize:       1,
+			WriteBackoffMin: 1 * time.Millisecond,
+		},
+		Read: event.ReaderConfig{},
+	})
+
+	return &Kafka{sender: sender}, cancel, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, envs ...*Envelope) error {
+	msg := make([]event.Event, 0, len(envs))
+	for _, e := range envs {
+		msg = append(msg, e.Event())
+	}
+
+	return k.sender.Send(ctx, msg...)
+}
+
+// File 将事件逐行写入 NDJSON 文件, 用于审阅或之后通过 replay-events 重发
+type File struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// OpenFile 以追加方式打开事件文件
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (f *File) Publish(_ context.Context, envs ...*Envelope) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range envs {
+		if err := f.enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// maxLine 单个事件的最大长度, 素材事件可能包含较多的多语言字段
+const maxLine = 16 << 20
+
+// Read 按行读取事件文件
+func Read(path string, fn func(line int, e *Envelope) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), maxLine)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		e := new(Envelope)
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if err := fn(line, e); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Envelope{
+		{
+			Topic:   "operational-materials-svc.material.operate",
+			Key:     "63a51a0fe99dc512b16e916b",
+			Headers: map[string]string{"scope": "camera360", "env": "qa"},
+			TrackID: "63a51a0fe99dc512b16e916bsync_by_cli",
+			Payload: []byte(`{"operateType":"Create"}`),
+		},
+		{
+			Topic:   "operational-h5-svc.properties.operate",
+			Key:     "63bb8379b52c0f797ff1810f",
+			Headers: map[string]string{"scope": "icc", "env": "dev"},
+			Payload: []byte(`{"data":[]}`),
+		},
+	}
+	if err := f.Publish(context.Background(), want...); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []*Envelope{}
+	if err := Read(path, func(_ int, e *Envelope) error {
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
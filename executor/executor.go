@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats 单元内的处理统计, 只由所属单元自己修改
+type Stats struct {
+	Processed int
+	Failed    int
+}
+
+// Unit 一个可独立执行的工作单元, 通常对应一个 <scope>_<env>
+type Unit struct {
+	Name string
+	Run  func(ctx context.Context, st *Stats) error
+}
+
+// Result 单元的执行结果
+type Result struct {
+	Name    string
+	Stats   Stats
+	Err     error
+	Elapsed time.Duration
+}
+
+// Run 以最多 concurrency 个并发执行全部单元, 单元之间的错误(含 panic)互不影响
+// 返回结果与 units 顺序一致
+func Run(ctx context.Context, concurrency int, units []Unit) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]Result, len(units))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i := range units {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = run(ctx, units[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func run(ctx context.Context, u Unit) (res Result) {
+	res.Name = u.Name
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("panic: %v", r)
+		}
+		res.Elapsed = time.Since(start)
+	}()
+
+	res.Err = u.Run(ctx, &res.Stats)
+
+	return res
+}
+
+// Err 汇总失败的单元, 全部成功时返回nil
+func Err(results []Result) error {
+	failed := []string{}
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d units failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+}
+
+// PrintSummary 按单元输出执行结果
+func PrintSummary(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "UNIT\tPROCESSED\tFAILED\tELAPSED\tERROR\n")
+	for _, r := range results {
+		errMsg := "-"
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", r.Name, r.Stats.Processed, r.Stats.Failed, r.Elapsed.Round(time.Millisecond), errMsg)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var running, peak int32
+	unit := func(name string, fail error, panics bool) Unit {
+		return Unit{Name: name, Run: func(_ context.Context, st *Stats) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			if panics {
+				panic("boom")
+			}
+			st.Processed++
+
+			return fail
+		}}
+	}
+
+	results := Run(context.Background(), 2, []Unit{
+		unit("a", nil, false),
+		unit("b", errors.New("bad"), false),
+		unit("c", nil, true),
+		unit("d", nil, false),
+	})
+
+	if peak > 2 {
+		t.Errorf("peak concurrency = %d, want <= 2", peak)
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if results[i].Name != name {
+			t.Fatalf("results[%d] = %s, want %s", i, results[i].Name, name)
+		}
+	}
+	if results[0].Err != nil || results[0].Stats.Processed != 1 {
+		t.Errorf("a = %+v", results[0])
+	}
+	if results[1].Err == nil || results[2].Err == nil || results[3].Err != nil {
+		t.Errorf("errors not isolated: %+v", results)
+	}
+	if err := Err(results); err == nil || err.Error() != "2 of 4 units failed: b, c" {
+		t.Errorf("Err = %v", err)
+	}
+}
@@ -12,6 +12,7 @@ require (
 	github.com/pinguo-icc/operational-materials-svc v1.0.1-0.20221222093630-e565d9a2a148
 	go.mongodb.org/mongo-driver v1.10.0
 	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/apimachinery v0.24.4
 )
 
@@ -77,7 +78,6 @@ require (
 	google.golang.org/genproto v0.0.0-20220519153652-3a47de7e79bd // indirect
 	google.golang.org/grpc v1.50.1 // indirect
 	gopkg.in/ini.v1 v1.66.4 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
 
 // replace github.com/pinguo-icc/operational-materials-svc => ../operational-materials-svc
@@ -0,0 +1,444 @@
+// Package h5patch 按规则文件修改 h5 样式(h5.properties)中 style、attribute 两个 JSON 字符串
+package h5patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Target 规则作用的字段
+const (
+	TargetStyle     = "style"
+	TargetAttribute = "attribute"
+)
+
+// Op 修改操作
+const (
+	OpSet    = "set"
+	OpUnset  = "unset"
+	OpRename = "rename"
+)
+
+// Rules 规则文件, 按顺序对每个文档执行, 后面的规则可看到前面规则的修改
+type Rules struct {
+	Rules []*Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule 一条规则, FieldDefCodes 与 Match 均满足时依次执行 Operations
+type Rule struct {
+	Name string `yaml:"name" json:"name"`
+	// FieldDefCodes 物料的 fieldDefCode, 为空时不限制
+	FieldDefCodes []string     `yaml:"fieldDefCodes" json:"fieldDefCodes"`
+	Match         []*Condition `yaml:"match" json:"match"`
+	Operations    []*Operation `yaml:"operations" json:"operations"`
+}
+
+// Condition 对 JSON 路径的判断, 多个判断项同时指定时需全部满足
+// Any 不为空时为或条件, 其中任一条件满足即可
+type Condition struct {
+	Target string `yaml:"target" json:"target"`
+	// Path 点分路径, 数组使用下标, 如 animation.0.duration
+	Path   string       `yaml:"path" json:"path"`
+	Exists *bool        `yaml:"exists" json:"exists"`
+	Equals interface{}  `yaml:"equals" json:"equals"`
+	Lt     *float64     `yaml:"lt" json:"lt"`
+	Gt     *float64     `yaml:"gt" json:"gt"`
+	Any    []*Condition `yaml:"any" json:"any"`
+}
+
+// Operation 对 JSON 路径的修改, rename 将 Path 的值移动到 To
+type Operation struct {
+	Op     string      `yaml:"op" json:"op"`
+	Target string      `yaml:"target" json:"target"`
+	Path   string      `yaml:"path" json:"path"`
+	Value  interface{} `yaml:"value" json:"value"`
+	To     string      `yaml:"to" json:"to"`
+}
+
+// Doc 一条 h5 样式, Style、Attribute 为空字符串时视为空对象
+type Doc struct {
+	FieldDefCode string
+	Style        string
+	Attribute    string
+}
+
+// Load 加载 yaml 或 json(以 .json 结尾)规则文件并校验
+func Load(path string) (*Rules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := new(Rules)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, rs)
+	} else {
+		err = yaml.Unmarshal(b, rs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse rules %s: %w", path, err)
+	}
+
+	if err := rs.Validate(); err != nil {
+		return nil, fmt.Errorf("rules %s: %w", path, err)
+	}
+
+	return rs, nil
+}
+
+// Validate 校验规则, 并将 yaml 解析出的值转为 JSON 可编码的类型
+func (rs *Rules) Validate() error {
+	if len(rs.Rules) == 0 {
+		return errors.New("no rules")
+	}
+
+	for i, r := range rs.Rules {
+		if r.Name == "" {
+			r.Name = "#" + strconv.Itoa(i+1)
+		}
+		if len(r.Operations) == 0 {
+			return fmt.Errorf("rule %s has no operations", r.Name)
+		}
+
+		for _, c := range r.Match {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+		}
+
+		for _, o := range r.Operations {
+			if err := checkPath(o.Target, o.Path); err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			switch o.Op {
+			case OpSet:
+				o.Value = normalize(o.Value)
+			case OpUnset:
+			case OpRename:
+				if o.To == "" {
+					return fmt.Errorf("rule %s: rename %s without to", r.Name, o.Path)
+				}
+			default:
+				return fmt.Errorf("rule %s: unknown op %q, use set, unset or rename", r.Name, o.Op)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Condition) validate() error {
+	if len(c.Any) > 0 {
+		for _, a := range c.Any {
+			if err := a.validate(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if c.Exists == nil && c.Equals == nil && c.Lt == nil && c.Gt == nil {
+		return fmt.Errorf("condition on %s has nothing to check", c.Path)
+	}
+	c.Equals = normalize(c.Equals)
+
+	return checkPath(c.Target, c.Path)
+}
+
+func checkPath(target, path string) error {
+	if target != TargetStyle && target != TargetAttribute {
+		return fmt.Errorf("unknown target %q, use style or attribute", target)
+	}
+	if path == "" {
+		return fmt.Errorf("empty path on %s", target)
+	}
+
+	return nil
+}
+
+// normalize yaml.v2 将对象解析为 map[interface{}]interface{}, 转为 JSON 可编码的类型
+func normalize(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+
+		return m
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = normalize(e)
+		}
+	}
+
+	return v
+}
+
+// Apply 对文档执行全部规则, 返回生效的规则名, 无规则生效时 doc 不变
+func (rs *Rules) Apply(doc *Doc) ([]string, error) {
+	style, err := decode(doc.Style)
+	if err != nil {
+		return nil, fmt.Errorf("style: %w", err)
+	}
+	attr, err := decode(doc.Attribute)
+	if err != nil {
+		return nil, fmt.Errorf("attribute: %w", err)
+	}
+	targets := map[string]map[string]interface{}{TargetStyle: style, TargetAttribute: attr}
+
+	applied := []string{}
+	touched := map[string]bool{}
+	for _, r := range rs.Rules {
+		if !r.matches(doc.FieldDefCode, targets) {
+			continue
+		}
+		for _, o := range r.Operations {
+			o.apply(targets[o.Target])
+			touched[o.Target] = true
+		}
+		applied = append(applied, r.Name)
+	}
+
+	// 只重新编码被修改的字段, 其余字段保持原样
+	if touched[TargetStyle] {
+		if doc.Style, err = encode(style); err != nil {
+			return nil, err
+		}
+	}
+	if touched[TargetAttribute] {
+		if doc.Attribute, err = encode(attr); err != nil {
+			return nil, err
+		}
+	}
+
+	return applied, nil
+}
+
+func decode(s string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if s == "" {
+		return m, nil
+	}
+
+	// 保留数字的原始写法, 避免大整数变为浮点数
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func encode(m map[string]interface{}) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (r *Rule) matches(code string, targets map[string]map[string]interface{}) bool {
+	if len(r.FieldDefCodes) > 0 && !contains(r.FieldDefCodes, code) {
+		return false
+	}
+
+	for _, c := range r.Match {
+		if !c.matches(targets) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Condition) matches(targets map[string]map[string]interface{}) bool {
+	if len(c.Any) > 0 {
+		for _, a := range c.Any {
+			if a.matches(targets) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	v, ok := get(targets[c.Target], c.Path)
+	if c.Exists != nil && *c.Exists != ok {
+		return false
+	}
+	if c.Equals != nil && (!ok || !equal(v, c.Equals)) {
+		return false
+	}
+	if c.Lt != nil || c.Gt != nil {
+		f, isNum := number(v)
+		if !ok || !isNum || (c.Lt != nil && f >= *c.Lt) || (c.Gt != nil && f <= *c.Gt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (o *Operation) apply(m map[string]interface{}) {
+	switch o.Op {
+	case OpSet:
+		// 每个文档使用独立的副本, 避免之后的规则修改到规则本身的值
+		set(m, o.Path, deepCopy(o.Value))
+	case OpUnset:
+		unset(m, o.Path)
+	case OpRename:
+		if v, ok := get(m, o.Path); ok {
+			unset(m, o.Path)
+			set(m, o.To, v)
+		}
+	}
+}
+
+// deepCopy 复制 normalize 后的对象及数组
+func deepCopy(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[k] = deepCopy(e)
+		}
+
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(vv))
+		for i, e := range vv {
+			a[i] = deepCopy(e)
+		}
+
+		return a
+	}
+
+	return v
+}
+
+// get 按点分路径取值, 数组使用下标
+func get(m map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = m
+	for _, seg := range strings.Split(path, ".") {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			v, ok := c[seg]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			cur = c[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// set 按点分路径设置值, 缺少的对象会被创建, 路径经过非对象的值时不修改
+func set(m map[string]interface{}, path string, value interface{}) {
+	segs := strings.Split(path, ".")
+	var cur interface{} = m
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			if last {
+				c[seg] = value
+
+				return
+			}
+			next, ok := c[seg]
+			if !ok {
+				next = map[string]interface{}{}
+				c[seg] = next
+			}
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(c) {
+				return
+			}
+			if last {
+				c[idx] = value
+
+				return
+			}
+			cur = c[idx]
+		default:
+			return
+		}
+	}
+}
+
+func unset(m map[string]interface{}, path string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		delete(m, path)
+
+		return
+	}
+
+	if parent, ok := get(m, path[:i]); ok {
+		if pm, ok := parent.(map[string]interface{}); ok {
+			delete(pm, path[i+1:])
+		}
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch vv := v.(type) {
+	case json.Number:
+		f, err := vv.Float64()
+
+		return f, err == nil
+	case float64:
+		return vv, true
+	case int:
+		return float64(vv), true
+	}
+
+	return 0, false
+}
+
+// equal 数字按数值比较, 其它按 JSON 编码比较
+func equal(a, b interface{}) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+
+		return ok && fa == fb
+	}
+
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package h5patch
+
+import "testing"
+
+func TestPosCenter(t *testing.T) {
+	rules, err := Load("pos-center.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		doc  Doc
+		want string
+	}{
+		{name: "empty attribute", doc: Doc{FieldDefCode: "image-t1"}, want: `{"posCenter":true}`},
+		{name: "low zIndex", doc: Doc{FieldDefCode: "text-t1", Attribute: `{"zIndex":0}`}, want: `{"posCenter":true,"zIndex":0}`},
+		{name: "high zIndex", doc: Doc{FieldDefCode: "text-t1", Attribute: `{"zIndex":2}`}, want: `{"zIndex":2}`},
+		{name: "already set", doc: Doc{FieldDefCode: "image-t1", Attribute: `{"posCenter":false}`}, want: `{"posCenter":false}`},
+		{name: "other component", doc: Doc{FieldDefCode: "video-t1"}, want: ``},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			style := `{"width": 12345678901234567}`
+			c.doc.Style = style
+			if _, err := rules.Apply(&c.doc); err != nil {
+				t.Fatal(err)
+			}
+			if c.doc.Attribute != c.want {
+				t.Errorf("attribute = %s, want %s", c.doc.Attribute, c.want)
+			}
+			if c.doc.Style != style {
+				t.Errorf("style = %s, want unchanged", c.doc.Style)
+			}
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	rules := &Rules{Rules: []*Rule{{
+		Match:      []*Condition{{Target: TargetStyle, Path: "box.w", Equals: 10}},
+		Operations: []*Operation{{Op: OpRename, Target: TargetStyle, Path: "box.w", To: "box.width"}},
+	}}}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &Doc{Style: `{"box":{"w":10}}`}
+	if applied, err := rules.Apply(doc); err != nil || len(applied) != 1 {
+		t.Fatalf("applied = %v, err = %v", applied, err)
+	}
+	if doc.Style != `{"box":{"width":10}}` {
+		t.Errorf("style = %s", doc.Style)
+	}
+}
+
+func TestSetCopiesValue(t *testing.T) {
+	yes := true
+	rules := &Rules{Rules: []*Rule{
+		{Operations: []*Operation{{Op: OpSet, Target: TargetStyle, Path: "anim", Value: map[string]interface{}{"x": 1}}}},
+		{
+			Match:      []*Condition{{Target: TargetAttribute, Path: "moved", Exists: &yes}},
+			Operations: []*Operation{{Op: OpSet, Target: TargetStyle, Path: "anim.x", Value: 2}},
+		},
+	}}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		attribute, want string
+	}{
+		{`{"moved":true}`, `{"anim":{"x":2}}`},
+		{`{}`, `{"anim":{"x":1}}`},
+	} {
+		doc := &Doc{Attribute: c.attribute}
+		if _, err := rules.Apply(doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Style != c.want {
+			t.Errorf("style = %s, want %s", doc.Style, c.want)
+		}
+	}
+}
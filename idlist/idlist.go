@@ -0,0 +1,168 @@
+// Package idlist 从文件加载文档ID列表, 用于同步类命令按 scope/env 包含或排除文档
+package idlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// allSpaces 未指定 scope/env 的ID对全部空间生效
+const allSpaces = "*"
+
+// List 一个ID文件, 每行一个ID对全部空间生效, 或为 scope,env,id 仅对该空间生效
+// 空行、# 开头的行及 scope,env,id 表头忽略
+type List struct {
+	Path string
+	// ids scope_env => ID
+	ids map[string]sets.String
+
+	mu      sync.Mutex
+	matched map[string]sets.String
+}
+
+// Load 加载ID文件
+func Load(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	l.Path = path
+
+	return l, nil
+}
+
+func parse(r io.Reader) (*List, error) {
+	l := &List{ids: map[string]sets.String{}, matched: map[string]sets.String{}}
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.EqualFold(line, "scope,env,id") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		var space, id string
+		switch len(fields) {
+		case 1:
+			space, id = allSpaces, fields[0]
+		case 3:
+			space, id = fields[0]+"_"+fields[1], fields[2]
+		default:
+			return nil, fmt.Errorf("line %d: want id or scope,env,id, got %q", n, line)
+		}
+		if !primitive.IsValidObjectID(id) {
+			return nil, fmt.Errorf("line %d: invalid id %q", n, id)
+		}
+
+		if l.ids[space] == nil {
+			l.ids[space] = sets.NewString()
+		}
+		l.ids[space].Insert(id)
+	}
+
+	return l, sc.Err()
+}
+
+// Has ID 是否在 scope/env 的列表中, 并记为已匹配
+func (l *List) Has(scope, env, id string) bool {
+	space := scope + "_" + env
+	if !l.ids[space].Has(id) {
+		space = allSpaces
+		if !l.ids[space].Has(id) {
+			return false
+		}
+	}
+
+	l.mu.Lock()
+	if l.matched[space] == nil {
+		l.matched[space] = sets.NewString()
+	}
+	l.matched[space].Insert(id)
+	l.mu.Unlock()
+
+	return true
+}
+
+// Len 文件中的ID数
+func (l *List) Len() int {
+	n := 0
+	for _, s := range l.ids {
+		n += s.Len()
+	}
+
+	return n
+}
+
+// Matched 执行期间遇到的ID数, 其余ID在目标空间中未找到
+func (l *List) Matched() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, s := range l.matched {
+		n += s.Len()
+	}
+
+	return n
+}
+
+// Filter 包含及排除列表, 同时在两个列表中的ID被排除
+type Filter struct {
+	// Include 为nil时不限制, 否则只处理列表中的ID
+	Include *List
+	// Exclude 列表中的ID不处理
+	Exclude *List
+}
+
+// Allow 是否处理 scope/env 中的文档 id, Filter 为nil时全部处理
+func (f *Filter) Allow(scope, env, id string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.Exclude != nil && f.Exclude.Has(scope, env, id) {
+		return false
+	}
+
+	return f.Include == nil || f.Include.Has(scope, env, id)
+}
+
+// PrintSummary 输出各列表匹配到及未找到的ID数
+func (f *Filter) PrintSummary(w io.Writer) {
+	if f == nil {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "LIST\tFILE\tIDS\tMATCHED\tNOT FOUND\n")
+	for _, l := range []struct {
+		name string
+		list *List
+	}{{"include", f.Include}, {"exclude", f.Exclude}} {
+		if l.list == nil {
+			continue
+		}
+		n, m := l.list.Len(), l.list.Matched()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", l.name, l.list.Path, n, m, n-m)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,49 @@
+package idlist
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	include, err := parse(strings.NewReader(`scope,env,id
+# videobeats only
+videobeats, operation, 63a51a0fe99dc512b16e916b
+63bb8379b52c0f797ff1810f
+63bb83dcbdf592838b09a5d9
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := parse(strings.NewReader("63bb83dcbdf592838b09a5d9\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Filter{Include: include, Exclude: exclude}
+
+	for _, c := range []struct {
+		scope, env, id string
+		want           bool
+	}{
+		{"videobeats", "operation", "63a51a0fe99dc512b16e916b", true},
+		{"camera360", "operation", "63a51a0fe99dc512b16e916b", false},
+		{"camera360", "qa", "63bb8379b52c0f797ff1810f", true},
+		{"camera360", "qa", "63bb83dcbdf592838b09a5d9", false},
+		{"camera360", "qa", "6306eb731fbbb4d2e0a015a1", false},
+	} {
+		if got := f.Allow(c.scope, c.env, c.id); got != c.want {
+			t.Errorf("Allow(%s, %s, %s) = %t, want %t", c.scope, c.env, c.id, got, c.want)
+		}
+	}
+
+	if include.Len() != 3 || include.Matched() != 2 {
+		t.Errorf("include len %d matched %d, want 3 and 2", include.Len(), include.Matched())
+	}
+
+	if _, err := parse(strings.NewReader("camera360,63a51a0fe99dc512b16e916b\n")); err == nil {
+		t.Error("expect an error for a line with two fields")
+	}
+	if (*Filter)(nil).Allow("camera360", "qa", "x") != true {
+		t.Error("a nil filter should allow everything")
+	}
+}
@@ -0,0 +1,408 @@
+// Package indexes 按索引声明文件检查各目标空间集合的索引, 并创建缺少的索引
+package indexes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+)
+
+// 索引检查结果
+const (
+	// StatusOK 已有相同的索引
+	StatusOK = "ok"
+	// StatusMissing 声明的索引不存在, 将被创建
+	StatusMissing = "missing"
+	// StatusDifferent 同字段或同名的索引选项不一致, 需人工处理
+	StatusDifferent = "different"
+	// StatusExtra 集合中有未声明的索引, 只报告
+	StatusExtra = "extra"
+)
+
+// Spec 索引声明文件
+type Spec struct {
+	Indexes []*Index `yaml:"indexes" json:"indexes"`
+}
+
+// Index 一个索引的声明
+type Index struct {
+	// DB 不含 <scope>_<env>_ 前缀的库名
+	DB         string `yaml:"db" json:"db"`
+	Collection string `yaml:"collection" json:"collection"`
+	// Keys 按顺序的索引字段, - 开头为降序, 如 [rootID, -createdAt]
+	Keys []string `yaml:"keys" json:"keys"`
+	// Name 为空时使用 mongo 默认的名称, 如 rootID_1_createdAt_-1
+	Name               string `yaml:"name" json:"name"`
+	Unique             bool   `yaml:"unique" json:"unique"`
+	Sparse             bool   `yaml:"sparse" json:"sparse"`
+	ExpireAfterSeconds *int32 `yaml:"expireAfterSeconds" json:"expireAfterSeconds"`
+}
+
+// Load 加载 yaml 或 json(以 .json 结尾)声明文件并校验
+func Load(path string) (*Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(Spec)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, s)
+	} else {
+		err = yaml.Unmarshal(b, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse index spec %s: %w", path, err)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("index spec %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Validate 校验声明, 并补全默认的索引名
+func (s *Spec) Validate() error {
+	if len(s.Indexes) == 0 {
+		return errors.New("no indexes")
+	}
+
+	seen := map[string]bool{}
+	for i, ix := range s.Indexes {
+		if ix.DB == "" || ix.Collection == "" || len(ix.Keys) == 0 {
+			return fmt.Errorf("index #%d needs db, collection and keys", i+1)
+		}
+		for _, k := range ix.Keys {
+			if strings.TrimPrefix(k, "-") == "" {
+				return fmt.Errorf("index #%d has an empty key", i+1)
+			}
+		}
+		if ix.Name == "" {
+			ix.Name = keyName(ix.keys())
+		}
+
+		for _, k := range []string{"name:" + ix.Name, "keys:" + keyName(ix.keys())} {
+			k = ix.DB + "." + ix.Collection + " " + k
+			if seen[k] {
+				return fmt.Errorf("index %s is declared twice on %s.%s", ix.Name, ix.DB, ix.Collection)
+			}
+			seen[k] = true
+		}
+	}
+
+	return nil
+}
+
+// Collections 按库分组的集合名
+func (s *Spec) Collections() map[string][]string {
+	colls := map[string][]string{}
+	for _, ix := range s.Indexes {
+		if !containsString(colls[ix.DB], ix.Collection) {
+			colls[ix.DB] = append(colls[ix.DB], ix.Collection)
+		}
+	}
+
+	return colls
+}
+
+// For 声明在 db.coll 上的索引
+func (s *Spec) For(db, coll string) []*Index {
+	list := []*Index{}
+	for _, ix := range s.Indexes {
+		if ix.DB == db && ix.Collection == coll {
+			list = append(list, ix)
+		}
+	}
+
+	return list
+}
+
+func (ix *Index) keys() bson.D {
+	d := bson.D{}
+	for _, k := range ix.Keys {
+		if strings.HasPrefix(k, "-") {
+			d = append(d, bson.E{Key: k[1:], Value: -1})
+		} else {
+			d = append(d, bson.E{Key: k, Value: 1})
+		}
+	}
+
+	return d
+}
+
+// Model 创建索引使用的模型
+func (ix *Index) Model() mongo.IndexModel {
+	o := options.Index().SetName(ix.Name)
+	if ix.Unique {
+		o.SetUnique(true)
+	}
+	if ix.Sparse {
+		o.SetSparse(true)
+	}
+	if ix.ExpireAfterSeconds != nil {
+		o.SetExpireAfterSeconds(*ix.ExpireAfterSeconds)
+	}
+
+	return mongo.IndexModel{Keys: ix.keys(), Options: o}
+}
+
+// Existing Indexes().List 返回的索引
+type Existing struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+}
+
+// Diff 一个索引的检查结果, Index 为nil时是未声明的索引
+type Diff struct {
+	Status   string
+	Name     string
+	Index    *Index
+	Existing *Existing
+	Reason   string
+}
+
+// Compare 比较声明与已有的索引, 先按字段匹配, 再按名称匹配, _id_ 不参与比较
+func Compare(specs []*Index, existing []*Existing) []*Diff {
+	diffs := []*Diff{}
+	used := map[*Existing]bool{}
+	for _, ix := range specs {
+		d := &Diff{Status: StatusMissing, Name: ix.Name, Index: ix}
+		key := keyName(ix.keys())
+		for _, e := range existing {
+			if keyName(e.Key) == key {
+				d.Existing = e
+				break
+			}
+		}
+		if d.Existing == nil {
+			for _, e := range existing {
+				if e.Name == ix.Name {
+					d.Existing = e
+					break
+				}
+			}
+		}
+
+		if d.Existing != nil {
+			used[d.Existing] = true
+			d.Status = StatusOK
+			if reasons := differences(ix, d.Existing); len(reasons) > 0 {
+				d.Status, d.Reason = StatusDifferent, strings.Join(reasons, ", ")
+			}
+		}
+		diffs = append(diffs, d)
+	}
+
+	for _, e := range existing {
+		if used[e] || e.Name == "_id_" {
+			continue
+		}
+		diffs = append(diffs, &Diff{Status: StatusExtra, Name: e.Name, Existing: e, Reason: "keys " + keyName(e.Key)})
+	}
+
+	return diffs
+}
+
+func differences(ix *Index, e *Existing) []string {
+	reasons := []string{}
+	if k := keyName(e.Key); k != keyName(ix.keys()) {
+		reasons = append(reasons, fmt.Sprintf("keys %s, want %s", k, keyName(ix.keys())))
+	}
+	if e.Name != ix.Name {
+		reasons = append(reasons, fmt.Sprintf("name %s, want %s", e.Name, ix.Name))
+	}
+	if e.Unique != ix.Unique {
+		reasons = append(reasons, fmt.Sprintf("unique %t, want %t", e.Unique, ix.Unique))
+	}
+	if e.Sparse != ix.Sparse {
+		reasons = append(reasons, fmt.Sprintf("sparse %t, want %t", e.Sparse, ix.Sparse))
+	}
+	if ttl, want := ttlString(e.ExpireAfterSeconds), ttlString(ix.ExpireAfterSeconds); ttl != want {
+		reasons = append(reasons, fmt.Sprintf("expireAfterSeconds %s, want %s", ttl, want))
+	}
+
+	return reasons
+}
+
+func ttlString(v *int32) string {
+	if v == nil {
+		return "none"
+	}
+
+	return fmt.Sprint(*v)
+}
+
+// keyName 与 mongo 默认索引名一致的字段描述, 数值统一为 1/-1, 如 rootID_1_createdAt_-1
+func keyName(d bson.D) string {
+	parts := make([]string, 0, len(d))
+	for _, e := range d {
+		v := fmt.Sprint(e.Value)
+		switch n := e.Value.(type) {
+		case int32:
+			v = direction(float64(n))
+		case int64:
+			v = direction(float64(n))
+		case int:
+			v = direction(float64(n))
+		case float64:
+			v = direction(n)
+		}
+		parts = append(parts, e.Key+"_"+v)
+	}
+
+	return strings.Join(parts, "_")
+}
+
+func direction(n float64) string {
+	if n < 0 {
+		return "-1"
+	}
+
+	return "1"
+}
+
+// Ensure 检查集合的索引并创建缺少的, 集合不存在时不创建
+// 返回的错误仅为读取索引失败, 创建失败记录在 Result 中
+func Ensure(ctx context.Context, coll *mongo.Collection, specs []*Index, res *Result) error {
+	names, err := coll.Database().ListCollectionNames(ctx, bson.M{"name": coll.Name()})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		for _, ix := range specs {
+			res.add(coll, &Row{Diff: &Diff{Status: StatusMissing, Name: ix.Name, Index: ix, Reason: "collection does not exist"}})
+		}
+
+		return nil
+	}
+
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	existing := []*Existing{}
+	if err := cur.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	for _, d := range Compare(specs, existing) {
+		if d.Status != StatusMissing {
+			res.add(coll, &Row{Diff: d})
+
+			continue
+		}
+
+		_, err := store.Wrap(coll).CreateIndex(ctx, d.Index.Model())
+		res.add(coll, &Row{Diff: d, Created: err == nil, Err: err})
+	}
+
+	return nil
+}
+
+// Row 一个索引在某个集合上的结果
+type Row struct {
+	Database   string
+	Collection string
+	Diff       *Diff
+	// Created 缺少的索引已创建, dry-run 时表示将被创建
+	Created bool
+	Err     error
+}
+
+// Result 全部目标集合的检查结果
+type Result struct {
+	mu   sync.Mutex
+	Rows []*Row
+}
+
+func (r *Result) add(coll *mongo.Collection, row *Row) {
+	row.Database, row.Collection = coll.Database().Name(), coll.Name()
+
+	r.mu.Lock()
+	r.Rows = append(r.Rows, row)
+	r.mu.Unlock()
+}
+
+// Count 各状态的索引数
+func (r *Result) Count() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := map[string]int{}
+	for _, row := range r.Rows {
+		n[row.Diff.Status]++
+	}
+
+	return n
+}
+
+// Print 输出 ok 以外的索引及各状态的数量
+func (r *Result) Print(w io.Writer) {
+	r.mu.Lock()
+	rows := append([]*Row(nil), r.Rows...)
+	r.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+
+		return a.Diff.Name < b.Diff.Name
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "DATABASE\tCOLLECTION\tINDEX\tSTATUS\tDETAIL\n")
+	for _, row := range rows {
+		if row.Diff.Status == StatusOK {
+			continue
+		}
+
+		detail := row.Diff.Reason
+		switch {
+		case row.Err != nil:
+			detail = "create failed: " + row.Err.Error()
+		case row.Created && store.DryRun():
+			detail = "would be created"
+		case row.Created:
+			detail = "created"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.Database, row.Collection, row.Diff.Name, row.Diff.Status, detail)
+	}
+	tw.Flush()
+
+	n := r.Count()
+	fmt.Fprintf(w, "%d ok, %d missing, %d different, %d extra\n",
+		n[StatusOK], n[StatusMissing], n[StatusDifferent], n[StatusExtra])
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package indexes
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCompare(t *testing.T) {
+	s := new(Spec)
+	err := yaml.Unmarshal([]byte(`
+indexes:
+  - {db: operational-positions, collection: activity, keys: [rootID]}
+  - {db: operational-positions, collection: activity, keys: [pid, -createdAt]}
+  - {db: operational-positions, collection: activity, keys: [code], unique: true}
+  - {db: operational-positions, collection: activity, keys: [type]}
+`), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Indexes[1].Name != "pid_1_createdAt_-1" {
+		t.Errorf("default name %s", s.Indexes[1].Name)
+	}
+
+	existing := []*Existing{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "rootID_1", Key: bson.D{{Key: "rootID", Value: float64(1)}}},
+		{Name: "code_1", Key: bson.D{{Key: "code", Value: int32(1)}}},
+		{Name: "title_1", Key: bson.D{{Key: "title", Value: int64(1)}}},
+	}
+	got := map[string]string{}
+	for _, d := range Compare(s.For("operational-positions", "activity"), existing) {
+		got[d.Name] = d.Status
+	}
+	want := map[string]string{
+		"rootID_1":           StatusOK,
+		"pid_1_createdAt_-1": StatusMissing,
+		"code_1":             StatusDifferent,
+		"type_1":             StatusMissing,
+		"title_1":            StatusExtra,
+	}
+	for name, st := range want {
+		if got[name] != st {
+			t.Errorf("%s: got %q, want %q", name, got[name], st)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v", got)
+	}
+
+	s.Indexes = append(s.Indexes, &Index{DB: "operational-positions", Collection: "activity", Keys: []string{"rootID"}, Name: "root"})
+	if err := s.Validate(); err == nil {
+		t.Error("expect an error for keys declared twice")
+	}
+}
@@ -0,0 +1,231 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection 台账所在的集合名, 库名由 --admin-db 指定
+const Collection = "ledger"
+
+// Status 命令在单个库上的执行结果
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Entry 一次执行在单个目标库上的台账记录
+type Entry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	RunID      string             `bson:"runID"`
+	Action     string             `bson:"action"`
+	Version    string             `bson:"version"`
+	Database   string             `bson:"database"`
+	Status     Status             `bson:"status"`
+	StartedAt  time.Time          `bson:"startedAt"`
+	FinishedAt time.Time          `bson:"finishedAt,omitempty"`
+	Counts     store.Counts       `bson:"counts"`
+	Operator   string             `bson:"operator"`
+	Revision   string             `bson:"revision"`
+	Force      bool               `bson:"force"`
+	Error      string             `bson:"error,omitempty"`
+}
+
+// Run 一次命令执行, 同一次执行的所有台账记录共用 ID
+type Run struct {
+	ID        string
+	Action    string
+	Version   string
+	Operator  string
+	Revision  string
+	Force     bool
+	StartedAt time.Time
+	// Databases 执行前已知的目标库
+	Databases []string
+}
+
+// NewRun 生成一次新的执行
+func NewRun(action, version, operator string, force bool, dbs []string) *Run {
+	return &Run{
+		ID:        primitive.NewObjectID().Hex(),
+		Action:    action,
+		Version:   version,
+		Operator:  operator,
+		Revision:  Revision(),
+		Force:     force,
+		StartedAt: time.Now(),
+		Databases: dbs,
+	}
+}
+
+// Ledger 迁移台账, 直接读写集合, 不受 dry-run 影响
+type Ledger struct {
+	coll *mongo.Collection
+}
+
+func New(client *mongo.Client, db string) *Ledger {
+	return &Ledger{coll: client.Database(db).Collection(Collection)}
+}
+
+// Completed 返回 dbs 中该命令当前版本已成功执行过的库
+func (l *Ledger) Completed(ctx context.Context, action, version string, dbs []string) ([]string, error) {
+	res, err := l.coll.Distinct(ctx, "database", primitive.M{
+		"action":   action,
+		"version":  version,
+		"status":   StatusCompleted,
+		"database": primitive.M{"$in": dbs},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]string, 0, len(res))
+	for _, v := range res {
+		if s, ok := v.(string); ok {
+			done = append(done, s)
+		}
+	}
+	sort.Strings(done)
+
+	return done, nil
+}
+
+// Begin 为每个目标库写入一条执行中的记录
+func (l *Ledger) Begin(ctx context.Context, run *Run) error {
+	if len(run.Databases) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(run.Databases))
+	for _, db := range run.Databases {
+		docs = append(docs, run.entry(db, StatusRunning))
+	}
+
+	_, err := l.coll.InsertMany(ctx, docs)
+
+	return err
+}
+
+// Finish 按库写入执行结果, stats 中出现但执行前未知的库也会补录
+// 库的状态取自负责该库的执行单元(单元名为库名或 <scope>_<env>), 没有对应单元时取 runErr
+func (l *Ledger) Finish(
+	ctx context.Context, run *Run, stats map[string]store.Counts, results []executor.Result, runErr error,
+) error {
+	dbs := append([]string{}, run.Databases...)
+	for db := range stats {
+		if !contains(run.Databases, db) {
+			dbs = append(dbs, db)
+		}
+	}
+
+	for _, db := range dbs {
+		e := run.entry(db, StatusCompleted)
+		e.FinishedAt = time.Now()
+		e.Counts = stats[db]
+		if err := dbErr(db, results, runErr); err != nil {
+			e.Status, e.Error = StatusFailed, err.Error()
+		} else if e.Counts.Failed > 0 {
+			e.Status = StatusFailed
+		}
+
+		_, err := l.coll.UpdateOne(
+			ctx,
+			primitive.M{"runID": run.ID, "database": db},
+			primitive.M{"$set": e},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dbErr 负责 db 的执行单元中第一个错误, 单元有失败文档时也视为失败
+// 没有负责 db 的单元时返回 runErr
+func dbErr(db string, results []executor.Result, runErr error) error {
+	covered := false
+	for _, r := range results {
+		if r.Name != db && !strings.HasPrefix(db, r.Name+"_") {
+			continue
+		}
+
+		covered = true
+		if r.Err != nil {
+			return fmt.Errorf("%s: %w", r.Name, r.Err)
+		}
+		if r.Stats.Failed > 0 {
+			return fmt.Errorf("%s: %d failed", r.Name, r.Stats.Failed)
+		}
+	}
+	if !covered {
+		return runErr
+	}
+
+	return nil
+}
+
+func (r *Run) entry(db string, status Status) *Entry {
+	return &Entry{
+		RunID:     r.ID,
+		Action:    r.Action,
+		Version:   r.Version,
+		Database:  db,
+		Status:    status,
+		StartedAt: r.StartedAt,
+		Operator:  r.Operator,
+		Revision:  r.Revision,
+		Force:     r.Force,
+	}
+}
+
+// Revision 返回构建时的 git 版本, 工作区有未提交修改时追加 -dirty
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	rev, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+
+	if rev == "" {
+		return "unknown"
+	}
+	if dirty {
+		rev += "-dirty"
+	}
+
+	return rev
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pinguo-icc/mongodbcli/executor"
+)
+
+func TestDBErr(t *testing.T) {
+	runErr := errors.New("1 of 3 units failed: camera360_qa")
+	results := []executor.Result{
+		{Name: "camera360_prod"},
+		{Name: "camera360_qa", Err: errors.New("boom")},
+		{Name: "icc_qa_h5", Stats: executor.Stats{Failed: 2}},
+	}
+
+	for db, failed := range map[string]bool{
+		"camera360_prod_operational_materials": false,
+		"camera360_qa_operational_materials":   true,
+		"icc_qa_h5":                            true,
+		// 没有对应的执行单元时取 runErr
+		"icc_prod_h5": true,
+	} {
+		if err := dbErr(db, results, runErr); (err != nil) != failed {
+			t.Errorf("%s: err = %v, want failed %t", db, err, failed)
+		}
+	}
+
+	if err := dbErr("icc_prod_h5", results, nil); err != nil {
+		t.Errorf("err = %v, want nil without runErr", err)
+	}
+}
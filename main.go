@@ -8,13 +8,20 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/pinguo-icc/go-base/v2/event"
-	"github.com/pinguo-icc/go-lib/dao"
 	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/backup"
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/ledger"
 	"github.com/pinguo-icc/mongodbcli/material"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -23,78 +30,56 @@ import (
 type dbEntity struct {
 	dbName string
 	coll   []string
-	scope  map[string][]string
 }
 
-func (e *dbEntity) dbNames() []string {
+func (e *dbEntity) dbNames(m config.Matrix) []string {
 	names := []string{}
-	for k, v := range e.scope {
-		for _, env := range v {
-			names = append(names, fmt.Sprintf("%s_%s_%s", k, env, e.dbName))
-		}
+	for _, sp := range m.Spaces() {
+		names = append(names, sp.DBName(e.dbName))
 	}
 
 	return names
 }
 
-func (e *dbEntity) run(ctx context.Context, client *mongo.Client, fun func(ctx context.Context, coll *mongo.Collection) error) {
-	for _, v := range e.dbNames() {
-		log.Printf("======run on db %s ===========\n", v)
-
-		db := client.Database(v)
-		for _, c := range e.coll {
-			col := db.Collection(c)
-			if err := fun(ctx, col); err != nil {
-				log.Println(err)
-			}
-		}
-
-		log.Printf("======run finished =========== \n\n")
-	}
-}
-
-// setActivityActivityType 添加物料类型类型
-func setActivityActivityType(ctx context.Context, coll *mongo.Collection) error {
-	filter := primitive.M{"type": primitive.M{"$exists": false}}
-	update := primitive.M{"$set": primitive.M{"type": 1}}
-
-	u, err := coll.UpdateMany(ctx, filter, update)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("%s %d doc has been set type field.\n", coll.Name(), u.ModifiedCount)
-
-	return nil
-}
-
-// fixActivityActive 修复运营物料上下架状态
-func fixActivityActive(ctx context.Context, coll *mongo.Collection) error {
-	filter := primitive.M{"active": nil}
-	update := primitive.M{"$set": primitive.M{"active": false}}
-	u, err := coll.UpdateMany(ctx, filter, update)
-	if err != nil {
-		return err
-	}
+// units 每个库作为一个执行单元, 单个集合失败不影响其他集合
+func (e *dbEntity) units(
+	client *mongo.Client, m config.Matrix,
+	fun func(ctx context.Context, coll *mongo.Collection) error,
+) []executor.Unit {
+	colls := e.coll
+	units := []executor.Unit{}
+	for _, v := range e.dbNames(m) {
+		v := v
+		units = append(units, executor.Unit{
+			Name: v,
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				log.Printf("======run on db %s ===========\n", v)
+
+				db := client.Database(v)
+				var errs []string
+				for _, c := range colls {
+					col := db.Collection(c)
+					if err := fun(ctx, col); err != nil {
+						log.Println(err)
+						st.Failed++
+						errs = append(errs, fmt.Sprintf("%s: %v", c, err))
 
-	log.Printf("%s %d doc has been set active field.\n", coll.Name(), u.ModifiedCount)
-
-	return nil
-}
+						continue
+					}
+					st.Processed++
+				}
 
-// setSoftDeleteField 添加软删除
-func setSoftDeleteField(ctx context.Context, coll *mongo.Collection) error {
-	filter := primitive.M{"isDeleted": primitive.M{"$exists": false}}
-	update := primitive.M{"$set": primitive.M{"isDeleted": false}}
+				log.Printf("======run finished =========== \n\n")
+				if len(errs) > 0 {
+					return errors.New(strings.Join(errs, "; "))
+				}
 
-	u, err := coll.UpdateMany(ctx, filter, update)
-	if err != nil {
-		return err
+				return nil
+			},
+		})
 	}
 
-	log.Printf("%s %d doc has been set isDeleted field.\n", coll.Name(), u.ModifiedCount)
-
-	return nil
+	return units
 }
 
 // fixH5ActivityType 修复h5以及H5模版数据类型不一致问题
@@ -145,7 +130,7 @@ func fixH5NodeType(ctx context.Context, coll *mongo.Collection, t *Activity) {
 
 	update := primitive.M{"$set": primitive.M{"type": t.Type}}
 
-	u, err := coll.UpdateMany(ctx, filter, update)
+	u, err := store.Wrap(coll).UpdateMany(ctx, filter, update)
 	if err != nil {
 		return
 	}
@@ -165,7 +150,7 @@ func fixH5NodeActive(ctx context.Context, coll *mongo.Collection, t *Activity) {
 
 	update := primitive.M{"$set": primitive.M{"active": t.Active}}
 
-	u, err := coll.UpdateMany(ctx, filter, update)
+	u, err := store.Wrap(coll).UpdateMany(ctx, filter, update)
 	if err != nil {
 		return
 	}
@@ -211,26 +196,11 @@ func fixH5ActivityActStatus(ctx context.Context, coll *mongo.Collection) error {
 	return nil
 }
 
-var execs []dbEntity
-
-func init() {
-	execs = []dbEntity{
-		{
-			dbName: "operational-positions",
-			coll:   []string{"activity"},
-			scope: map[string][]string{
-				"videobeats": {"prod", "operation", "dev", "qa", "pre"},
-				"camera360":  {"prod", "operation", "dev", "qa", "pre"},
-				"idphoto":    {"prod", "operation", "dev", "qa", "pre"},
-				"mix":        {"prod", "operation", "dev", "qa", "pre"},
-				"salad":      {"prod", "operation", "dev", "qa", "pre"},
-				"inface":     {"prod", "operation", "dev", "qa", "pre"},
-				"april":      {"prod", "operation", "dev", "qa", "pre"},
-				"icc":        {"prod", "operation", "dev", "qa", "pre"},
-				//"icc": {"dev"},
-			},
-		},
-	}
+var execs = []dbEntity{
+	{
+		dbName: "operational-positions",
+		coll:   []string{"activity"},
+	},
 }
 
 type option struct {
@@ -238,21 +208,74 @@ type option struct {
 	Timeout       int
 	Action        string
 	BmallMondoDNS string
+	Config        string
+	Scope         string
+	Env           string
+	DryRun        bool
+	AdminDB       string
+	Force         bool
+	Operator      string
+	Backup        string
+	Concurrency   int
+	MQProfile     string
+	EventsFile    string
+	ReportDir     string
+	ReportFormat  string
 }
 
-func (o *option) validate() error {
-	if o.MongoDNS == "" {
+// validate 校验命令所需的连接参数是否齐全
+func (o *option) validate(c *command) error {
+	if c.conns&connMongo != 0 && o.MongoDNS == "" {
 		return errors.New("please set mongo connect uri")
 	}
 
+	if c.conns&connBmall != 0 && o.BmallMondoDNS == "" {
+		return errors.New("must set bmall mongoDB dsn")
+	}
+
+	if _, err := report.ParseFormats(o.ReportFormat); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (o *option) addFlags(fs *flag.FlagSet) {
 	fs.StringVar(&o.MongoDNS, "mongo-dns", "", "the mongoDB connect address")
 	fs.IntVar(&o.Timeout, "timeout", 1, "the exec timeout setting,default 1 minute")
-	fs.StringVar(&o.Action, "action", "all", "the exec action,default all")
+	fs.StringVar(&o.Action, "action", "", "the exec action, same as passing the action as first argument")
 	fs.StringVar(&o.BmallMondoDNS, "bmall-mongo-dns", "", "the bmall-bff mongo connect address")
+	fs.StringVar(&o.Config, "config", defaultConfigFile, "the scope/env config file, yaml or json")
+	fs.StringVar(&o.Scope, "scope", "", "comma separated scopes, override the config, e.g. camera360,icc")
+	fs.StringVar(&o.Env, "env", "", "comma separated envs, override the config, e.g. qa,dev")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "only count the documents that would be written and print the plan, nothing is written to mongo or kafka")
+	fs.StringVar(&o.AdminDB, "admin-db", "mongodbcli", "the database keeping the migration ledger")
+	fs.BoolVar(&o.Force, "force", false, "re-run a one-shot action on databases where it has already completed")
+	fs.StringVar(&o.Operator, "operator", os.Getenv("USER"), "who runs the action, recorded in the ledger")
+	fs.StringVar(&o.MQProfile, "mq-profile", "", "the kafka profile in the config file to send events with, e.g. dev, qa, prod")
+	fs.StringVar(&o.EventsFile, "events-file", "", "write events to this NDJSON file instead of kafka, publish it later with replay-events")
+	fs.IntVar(&o.Concurrency, "concurrency", 1, "how many scope/env databases an action processes in parallel")
+	fs.StringVar(&o.ReportDir, "report-dir", ".", "the directory to write the report of failed documents to")
+	fs.StringVar(&o.ReportFormat, "report-format", "ndjson,csv", "comma separated report formats: json, ndjson, csv")
+	fs.StringVar(&o.Backup, "backup", "", "snapshot documents before changing them so the run can be rolled back: db for the admin database, otherwise a local directory")
+}
+
+const defaultConfigFile = "mongodbcli.yaml"
+
+// loadConfig 加载配置文件, 未显式指定且默认文件不存在时返回nil
+func (o *option) loadConfig(fs *flag.FlagSet) (*config.Config, error) {
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	if _, err := os.Stat(o.Config); err != nil && !explicit && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return config.Load(o.Config)
 }
 
 func initOptions(fs *flag.FlagSet, args ...string) (*option, error) {
@@ -261,21 +284,103 @@ func initOptions(fs *flag.FlagSet, args ...string) (*option, error) {
 	if err := fs.Parse(args); err != nil {
 		return nil, err
 	}
-	if err := o.validate(); err != nil {
-		return nil, err
-	}
 
 	return o, nil
 }
 
-func exec(ctx context.Context, cli *mongo.Client) {
+// connect 按命令声明建立所需的数据库连接
+func connect(ctx context.Context, rt *runtime, conns connection) error {
+	o := rt.opt
+	if conns&connMongo != 0 {
+		cli, err := mongo.Connect(ctx, options.Client().ApplyURI(o.MongoDNS))
+		if err != nil {
+			return err
+		}
+		rt.mongo = cli
+	}
+
+	if conns&connBmall != 0 {
+		cli, err := mongo.Connect(ctx, options.Client().ApplyURI(o.BmallMondoDNS))
+		if err != nil {
+			return err
+		}
+		rt.bmall = cli
+	}
+
+	return nil
+}
+
+func exec(
+	ctx context.Context, cli *mongo.Client, opt *material.Options,
+	fun func(ctx context.Context, coll *mongo.Collection) error,
+) error {
+	units := []executor.Unit{}
 	for _, v := range execs {
-		v.run(ctx, cli, fixH5ActivityActStatus)
+		units = append(units, v.units(cli, opt.Matrix, fun)...)
 	}
+
+	return opt.Run(ctx, units)
 }
 
 func main() {
-	o, err := initOptions(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:]...)
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() { printUsage(os.Stderr, fs) }
+	o, err := initOptions(fs, os.Args[1:]...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name, args := o.Action, fs.Args()
+	if name == "" {
+		if len(args) == 0 {
+			printUsage(os.Stderr, fs)
+			os.Exit(2)
+		}
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "help":
+		if len(args) == 0 {
+			printUsage(os.Stdout, fs)
+
+			return
+		}
+
+		c, err := lookup(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.usage(os.Stdout, c.flagSet(os.Stdout))
+
+		return
+	case "list":
+		printList(os.Stdout)
+
+		return
+	}
+
+	c, err := lookup(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfs := c.flagSet(os.Stderr)
+	if err := cfs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	if cfs.NArg() > 0 {
+		log.Fatalf("unexpected arguments for %s: %s", c.name, strings.Join(cfs.Args(), " "))
+	}
+
+	if err := o.validate(c); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := o.loadConfig(fs)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -283,94 +388,135 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(o.Timeout)*time.Minute)
 	defer cancel()
 
-	dbCli, err := mongo.Connect(ctx, options.Client().ApplyURI(o.MongoDNS))
+	rt := &runtime{opt: o, cfg: cfg, action: c.name}
+	if err := connect(ctx, rt, c.conns); err != nil {
+		log.Fatal(err)
+	}
+
+	store.SetDryRun(o.DryRun)
+	lg, err := beginLedger(ctx, rt, c)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	switch o.Action {
-	case "syncMaterials":
-		//同步素材
-		if err := material.SyncMaterials(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "syncUnitFontMaterials":
-		if err := material.SyncUnityFontMaterials(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "syncMaterialCategorys":
-		// 同步分类
-		if err := material.SyncMaterialCategorys(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "syncMaterialsPosition":
-		//同步素材位置
-		if err := material.SyncMaterialsPosition(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "syncMaterialsPlan":
-		//同步素材位置计划
-		if err := material.SyncMaterialsPlan(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "clearMaterials":
-		// 清理素材
-		if err := material.ClearMaterials(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "syncH5Style":
-		// 同步h5样式
-		if err := syncH5Style(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "dealWithPlanBytraverse":
-		// 统计投放计划有无覆盖属性
-		if err := material.DealWithPlanBytraverse(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
+	bw, err := openBackup(rt)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	case "resetCategoryVersionID":
-		// 统计投放计划有无覆盖属性
-		if err := material.ResetMaterialCategoryVersionID(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "resetH5StyleMainID":
-		// 统计投放计划有无覆盖属性
-		if err := resetH5StyleMainID(ctx, dbCli); err != nil {
-			log.Fatal(err)
-		}
-	case "resetH5Type":
-		// 默认修复h5 type 类型
-		exec(ctx, dbCli)
-	case "resetH5Active":
-		// 默认修复h5 active状态 类型
-		exec(ctx, dbCli)
-	case "DealwithMaterialCategoryParentID":
-		if err := material.DealwithMaterialCategoryParentID(ctx, dbCli); err != nil {
-			log.Fatal(err)
+	runID := ""
+	if rt.run != nil {
+		runID = rt.run.ID
+	}
+	rt.report = report.New(runID, c.name)
+
+	runErr := c.run(ctx, rt)
+	writeReport(rt)
+	if bw != nil {
+		if err := bw.Close(); err != nil {
+			log.Printf("close backup of run %s failed: %v", rt.run.ID, err)
 		}
-	case "mapOfBmallAndOPS":
-		// 建立bmallID与ops ID的映射关系
-		if o.BmallMondoDNS == "" {
-			log.Fatal("must set bmall mongoDB dsn")
+	}
+	if lg != nil {
+		// 命令超时后 ctx 已失效, 台账使用独立的 context 写入
+		fctx, fcancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := lg.Finish(fctx, rt.run, store.Stats(), rt.results, runErr); err != nil {
+			log.Printf("write ledger of run %s failed: %v", rt.run.ID, err)
 		}
+		fcancel()
+	}
+	// 失败时同样输出 dry-run 计划, 再以非零状态退出
+	if o.DryRun {
+		store.PrintPlan(os.Stdout)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+}
 
-		bmallCli, err := mongo.Connect(ctx, options.Client().ApplyURI(o.BmallMondoDNS))
+// writeReport 输出失败文档的报告文件及按错误分类的汇总
+func writeReport(rt *runtime) {
+	if len(rt.report.Records()) == 0 {
+		return
+	}
+	rt.report.PrintSummary(os.Stdout)
+
+	// 格式已在 validate 中校验
+	formats, _ := report.ParseFormats(rt.opt.ReportFormat)
+	files, err := rt.report.Write(rt.opt.ReportDir, formats)
+	if err != nil {
+		log.Printf("write report failed: %v", err)
+	}
+	if len(files) > 0 {
+		log.Printf("failed documents are reported in %s", strings.Join(files, ", "))
+	}
+}
+
+// openBackup 按 --backup 开启写入前快照, dry-run 时不开启
+func openBackup(rt *runtime) (*backup.Writer, error) {
+	o := rt.opt
+	if o.Backup == "" || o.DryRun {
+		return nil, nil
+	}
+	if rt.run == nil {
+		return nil, errors.New("--backup needs the mongo connection of the action")
+	}
+
+	w, err := backup.NewWriter(o.Backup, rt.run.ID, rt.mongo, o.AdminDB)
+	if err != nil {
+		return nil, err
+	}
+	w.Conn(rt.mongo, connMongo.String())
+	w.Conn(rt.bmall, connBmall.String())
+	store.SetSnapshotter(w)
+	log.Printf("snapshots of run %s are kept in %s, roll back with `rollback --run %s --from %s`", rt.run.ID, o.Backup, rt.run.ID, o.Backup)
+
+	return w, nil
+}
+
+// beginLedger 检查一次性迁移是否已执行过, 并写入本次执行的台账
+// dry-run 或命令不连接 mongo 时不记录台账, 返回nil
+func beginLedger(ctx context.Context, rt *runtime, c *command) (*ledger.Ledger, error) {
+	if rt.mongo == nil {
+		return nil, nil
+	}
+
+	dbs := []string{}
+	if len(c.writes) > 0 || c.targets != nil {
+		m, err := rt.targets()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		dbs = c.targetDBs(m)
+	}
 
-		if err := material.CreateMapBetweenBmallAndOpsID(ctx, dbCli, bmallCli); err != nil {
-			log.Fatal(err)
+	o := rt.opt
+	lg := ledger.New(rt.mongo, o.AdminDB)
+	rt.run = ledger.NewRun(c.name, c.ver(), o.Operator, o.Force, dbs)
+
+	if c.oneShot && len(dbs) > 0 {
+		done, err := lg.Completed(ctx, c.name, c.ver(), dbs)
+		if err != nil {
+			return nil, err
 		}
-	case "initUgcCategoryVersionName":
-		if err := initUgcCategoryVersionName(ctx, dbCli); err != nil {
-			log.Fatalln(err)
+		if len(done) > 0 && !o.Force {
+			return nil, fmt.Errorf(
+				"%s (version %s) has already completed on %s, pass --force to run it again",
+				c.name, c.ver(), strings.Join(done, ", "),
+			)
 		}
-	default:
-		panic("no action" + o.Action)
 	}
 
+	if o.DryRun {
+		return nil, nil
+	}
+
+	if err := lg.Begin(ctx, rt.run); err != nil {
+		return nil, err
+	}
+	log.Printf("run %s of %s recorded in %s.%s", rt.run.ID, c.name, o.AdminDB, ledger.Collection)
+
+	return lg, nil
 }
 
 func insertDocument(ctx context.Context, client *mongo.Client) error {
@@ -399,229 +545,198 @@ func insertDocument(ctx context.Context, client *mongo.Client) error {
 }
 
 // 更改ugc分类的默认版本名为“初始版本”
-func initUgcCategoryVersionName(ctx context.Context, client *mongo.Client) error {
+func initUgcCategoryVersionName(ctx context.Context, client *mongo.Client, opt *material.Options) error {
 	log.Printf("==============run sync start =========== \n")
-	scope := map[string][]string{
-		"videobeats": {"prod", "operation", "dev", "qa", "pre"},
-		"camera360":  {"prod", "operation", "dev", "qa", "pre"},
-		"idphoto":    {"prod", "operation", "dev", "qa", "pre"},
-		"mix":        {"prod", "operation", "dev", "qa", "pre"},
-		"salad":      {"prod", "operation", "dev", "qa", "pre"},
-		"inface":     {"prod", "operation", "dev", "qa", "pre"},
-		"april":      {"prod", "operation", "dev", "qa", "pre"},
-		"icc":        {"prod", "operation", "dev", "qa", "pre"},
-		// "videobeats": {"qa"},
-	}
-	ugcDBNames := make([]string, 0)
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopEnv := fmt.Sprintf("%s_%s", sp, env)
-			ugccDbName := fmt.Sprintf("%s_%s", scopEnv, "operational_ugc")
-			ugcDBNames = append(ugcDBNames, ugccDbName)
-		}
-	}
+	units := []executor.Unit{}
+	for _, sp := range opt.Matrix.Spaces() {
+		dbName := sp.DBName("operational_ugc")
+		scope, env := sp.Scope, sp.Env
+		units = append(units, executor.Unit{
+			Name: sp.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				log.Printf("==============change version name on %s  %s collection ugcCategory  =========== \n", scope, env)
+				ugcColl := client.Database(dbName).Collection("ugcCategory")
+				res, err := store.Wrap(ugcColl).UpdateMany(
+					ctx,
+					primitive.M{
+						"versions": primitive.M{"$elemMatch": primitive.M{"versionName": "默认版本"}},
+					},
+					primitive.M{
+						"$set": primitive.M{"versions.$.versionName": "初始版本"},
+					},
+				)
+				if err != nil {
+					return err
+				}
 
-	for _, dbName := range ugcDBNames {
-		sps := strings.Split(dbName, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
+				st.Processed += int(res.ModifiedCount)
+				log.Printf("==============change %d  version name on %s  %s collection ugcCategory  =========== \n", res.ModifiedCount, scope, env)
 
-		scope, env := sps[0], sps[1]
-		log.Printf("==============change version name on %s  %s collection ugcCategory  =========== \n", scope, env)
-		ugcColl := client.Database(dbName).Collection("ugcCategory")
-		res, err := ugcColl.UpdateMany(
-			ctx,
-			primitive.M{
-				"versions": primitive.M{"$elemMatch": primitive.M{"versionName": "默认版本"}},
-			},
-			primitive.M{
-				"$set": primitive.M{"versions.$.versionName": "初始版本"},
+				return nil
 			},
-		)
-
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			log.Printf("==============change %d  version name on %s  %s collection ugcCategory  =========== \n", res.ModifiedCount, scope, env)
-		}
+		})
 	}
 
+	err := opt.Run(ctx, units)
 	log.Printf("==============run sync finished =========== \n")
 
-	return nil
+	return err
 }
 
-func syncH5Style(ctx context.Context, client *mongo.Client) error {
+func syncH5Style(ctx context.Context, client *mongo.Client, opt *material.Options, allowInvalid bool) error {
 	log.Printf("==============run sync start =========== \n")
-	mq, cancel := material.InitMQ()
-
-	scope := map[string][]string{
-		"videobeats": {"prod", "operation", "dev", "qa", "pre"},
-		"camera360":  {"prod", "operation", "dev", "qa", "pre"},
-		"idphoto":    {"prod", "operation", "dev", "qa", "pre"},
-		"mix":        {"prod", "operation", "dev", "qa", "pre"},
-		"salad":      {"prod", "operation", "dev", "qa", "pre"},
-		"inface":     {"prod", "operation", "dev", "qa", "pre"},
-		"april":      {"prod", "operation", "dev", "qa", "pre"},
-		// "icc": {"prod", "operation", "dev", "qa", "pre"},
-		// "icc": {"operation"},
-	}
-	dbActH5Names := make(map[string]string, 0)
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopEnv := fmt.Sprintf("%s_%s", sp, env)
-			actDbName := fmt.Sprintf("%s_%s", scopEnv, "operational-positions")
-			h5DbName := fmt.Sprintf("%s_%s", scopEnv, "h5")
-			dbActH5Names[actDbName] = h5DbName
-		}
+	mq, cancel, err := material.InitMQ(opt)
+	if err != nil {
+		return err
 	}
+	defer cancel()
 
-	for actDBName, h5DBName := range dbActH5Names {
-		log.Printf("==============run sync %s to %s start =========== \n", actDBName, h5DBName)
-		actColl := client.Database(actDBName).Collection("activity")
-		h5Coll := client.Database(h5DBName).Collection("properties")
-		sps := strings.Split(h5DBName, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
+	units := []executor.Unit{}
+	for _, sp := range opt.Matrix.Spaces() {
+		actDBName, h5DBName := sp.DBName("operational-positions"), sp.DBName("h5")
+		scope, env := sp.Scope, sp.Env
+		units = append(units, executor.Unit{
+			Name: sp.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				log.Printf("==============run sync %s to %s start =========== \n", actDBName, h5DBName)
+				actColl := client.Database(actDBName).Collection("activity")
+				h5Coll := client.Database(h5DBName).Collection("properties")
+				if err := doSyncH5Style(ctx, actColl, h5Coll, mq, opt.Report, opt.IDs, allowInvalid, st, scope, env); err != nil {
+					return fmt.Errorf("sync style %s to %s: %w", actDBName, h5DBName, err)
+				}
 
-		scope, env := sps[0], sps[1]
-		if err := doSyncH5Style(ctx, actColl, h5Coll, mq, scope, env); err != nil {
-			log.Printf("sync style %s to %s error :%v", actDBName, h5DBName, err)
-		}
+				log.Printf("==============run sync %s to %s end =========== \n", actDBName, h5DBName)
 
-		log.Printf("==============run sync %s to %s end =========== \n", actDBName, h5DBName)
+				return nil
+			},
+		})
 	}
 
+	err = opt.Run(ctx, units)
+	opt.Report.PrintFieldSummary(os.Stdout)
 	log.Printf("==============run sync finished =========== \n")
 
-	cancel()
-	return nil
+	return err
 }
 
-func resetH5StyleMainID(ctx context.Context, client *mongo.Client) error {
+func resetH5StyleMainID(ctx context.Context, client *mongo.Client, opt *material.Options) error {
 	log.Printf("==============run sync start =========== \n")
 	//mq, cancel := material.InitMQ()
 
-	scope := map[string][]string{
-		"videobeats": {"prod", "dev", "qa", "pre"},
-		"camera360":  {"prod", "dev", "qa", "pre"},
-		"idphoto":    {"prod", "dev", "qa", "pre"},
-		// "mix": {"prod", "dev", "qa", "pre"},
-		"salad":  {"prod", "dev", "qa", "pre"},
-		"inface": {"prod", "dev", "qa", "pre"},
-		"april":  {"prod", "dev", "qa", "pre"},
-		// "icc": {"prod", "dev", "qa", "pre"},
-		// "icc": {"operation"},
-	}
-
-	actDbs := make(map[string]dao.MongodbDAO)
-	h5Dbs := make(map[string]dao.MongodbDAO)
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopeEnv := fmt.Sprintf("%s_%s", sp, env)
-			actSpaceName := fmt.Sprintf("%s_%s", scopeEnv, "operational-positions")
-			actDbs[actSpaceName] = dao.NewMongodbDAO(client.Database(actSpaceName), "activity")
-			h5SpaceName := fmt.Sprintf("%s_%s", scopeEnv, "h5")
-			h5Dbs[h5SpaceName] = dao.NewMongodbDAO(client.Database(h5SpaceName), "properties")
-		}
-	}
-
-	for sp, envs := range scope {
-		operationDBName := fmt.Sprintf("%s_operation_%s", sp, "h5")
-		operationDB := dao.NewMongodbDAO(client.Database(operationDBName), "properties")
-		cur, err := operationDB.Collection().Find(ctx, primitive.M{})
-		if err != nil {
-			return err
-		}
-		var res []*H5Properties
-		if err := cur.All(ctx, &res); err != nil {
-			return err
-		}
+	// operation 空间为同步源, 不作为目标
+	units := []executor.Unit{}
+	for _, sp := range opt.Matrix.Without("operation").Spaces() {
+		sp := sp
+		units = append(units, executor.Unit{
+			Name: sp.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				operationDBName := fmt.Sprintf("%s_operation_%s", sp.Scope, "h5")
+				operationColl := client.Database(operationDBName).Collection("properties")
+				h5Coll := client.Database(sp.DBName("h5")).Collection("properties")
+				if err := doResetH5StyleMainID(ctx, operationColl, h5Coll, st, sp.Scope, sp.Env); err != nil {
+					return fmt.Errorf("reset h5 properties id of %s: %w", sp.DBName("h5"), err)
+				}
 
-		for i := range res {
-			t := res[i]
-			for _, env := range envs {
-				log.Printf("start sync opration h5 properties %s %s %s h5\n", t.ID.Hex(), sp, env)
-				scopeEnv := fmt.Sprintf("%s_%s", sp, env)
-				spaceName := fmt.Sprintf("%s_%s", scopeEnv, "h5")
-				// spaceActName := fmt.Sprintf("%s_%s", scopeEnv, "operational-positions")
-
-				h5Coll := h5Dbs[spaceName]
-				//actColl := actDbs[spaceActName]
-				h5p := new(H5Properties)
-				err = h5Coll.Collection().FindOne(ctx, primitive.M{"activityID": t.ActivityID}).Decode(h5p)
-				if err != nil {
-					if err == mongo.ErrNoDocuments {
-						log.Printf("end sync opration h5 properties %s %s %s with not found \n ", t.ID.Hex(), sp, env)
+				return nil
+			},
+		})
+	}
 
-						continue
-					}
+	//cancel()
 
-					return err
-				}
+	return opt.Run(ctx, units)
+}
 
-				_, err := h5Coll.Collection().DeleteOne(context.TODO(), primitive.M{"_id": h5p.ID})
-				if err != nil {
-					fmt.Println(err)
-				}
+// doResetH5StyleMainID 将 h5Coll 中的 h5 属性 _id 重置为 operation 空间中同一活动的属性 _id
+// 删除旧文档失败时停止, 写入失败计入 st.Failed
+func doResetH5StyleMainID(ctx context.Context, operationColl, h5Coll *mongo.Collection, st *executor.Stats, sp, env string) error {
+	cur, err := operationColl.Find(ctx, primitive.M{})
+	if err != nil {
+		return err
+	}
+	var res []*H5Properties
+	if err := cur.All(ctx, &res); err != nil {
+		return err
+	}
 
-				h5p.ID = t.ID
-				_, err = h5Coll.Collection().UpdateByID(context.TODO(), t.ID, op.Set(t), options.Update().SetUpsert(true))
-				if err != nil {
-					log.Printf("%d reset h5 properties %s failed,error: %s \n", i, t.ID.Hex(), err)
-				} else {
-					log.Printf("%d reset h5 properties %+v success \n", i, t)
-				}
+	for i := range res {
+		t := res[i]
+		log.Printf("start sync opration h5 properties %s %s %s h5\n", t.ID.Hex(), sp, env)
+		h5p := new(H5Properties)
+		err = h5Coll.FindOne(ctx, primitive.M{"activityID": t.ActivityID}).Decode(h5p)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				log.Printf("end sync opration h5 properties %s %s %s with not found \n ", t.ID.Hex(), sp, env)
 
-				// projection := primitive.M{
-				// 	"_id":    1,
-				// 	"rootID": 1,
-				// 	"name":   1,
-				// 	"extral": 1,
-				// 	"pid":    1,
-				// 	"scope":  1,
-				// 	"type":   1,
-				// }
-
-				// actID, err := primitive.ObjectIDFromHex(t.ActivityID)
-				// if err != nil {
-				// 	return err
-				// }
-
-				// act := new(Activity)
-				// if err := actColl.Collection().FindOne(
-				// 	context.Background(), primitive.M{"_id": actID}, options.FindOne().SetProjection(projection),
-				// ).Decode(act); err != nil {
-				// 	return err
-				// }
-
-				// msg := []*material.H5PropertiesWithActName{{
-				// 	ID:        t.ID.Hex(),
-				// 	Attribute: t.Attribute,
-				// 	Style:     t.Style,
-				// 	ActID:     t.ActivityID,
-				// 	ActName:   act.Name,
-				// }}
-
-				// if err := material.SendOperitionPositionCreateMesssage(context.Background(), mq, sp, env, msg); err != nil {
-				// 	log.Printf(err.Error())
-				// }
-
-				log.Printf("end sync opration h5 properties %s %s %s h5\n ", t.ID.Hex(), sp, env)
+				continue
 			}
 
+			return err
 		}
 
-	}
+		if _, err := store.Wrap(h5Coll).DeleteOne(ctx, primitive.M{"_id": h5p.ID}); err != nil {
+			return fmt.Errorf("delete h5 properties %s: %w", h5p.ID.Hex(), err)
+		}
 
-	//cancel()
+		// 只替换 _id, 保留目标环境自己的 style 及 attribute
+		h5p.ID = t.ID
+		_, err = store.Wrap(h5Coll).UpdateByID(ctx, t.ID, op.Set(h5p), options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("%d reset h5 properties %s failed,error: %s \n", i, t.ID.Hex(), err)
+			st.Failed++
+
+			continue
+		}
+		log.Printf("%d reset h5 properties %+v success \n", i, h5p)
+		st.Processed++
+
+		// projection := primitive.M{
+		// 	"_id":    1,
+		// 	"rootID": 1,
+		// 	"name":   1,
+		// 	"extral": 1,
+		// 	"pid":    1,
+		// 	"scope":  1,
+		// 	"type":   1,
+		// }
+
+		// actID, err := primitive.ObjectIDFromHex(t.ActivityID)
+		// if err != nil {
+		// 	return err
+		// }
+
+		// act := new(Activity)
+		// if err := actColl.Collection().FindOne(
+		// 	context.Background(), primitive.M{"_id": actID}, options.FindOne().SetProjection(projection),
+		// ).Decode(act); err != nil {
+		// 	return err
+		// }
+
+		// msg := []*material.H5PropertiesWithActName{{
+		// 	ID:        t.ID.Hex(),
+		// 	Attribute: t.Attribute,
+		// 	Style:     t.Style,
+		// 	ActID:     t.ActivityID,
+		// 	ActName:   act.Name,
+		// }}
+
+		// if err := material.SendOperitionPositionCreateMesssage(context.Background(), mq, sp, env, msg); err != nil {
+		// 	log.Printf(err.Error())
+		// }
+
+		log.Printf("end sync opration h5 properties %s %s %s h5\n ", t.ID.Hex(), sp, env)
+	}
 
 	return nil
 }
 
-func doSyncH5Style(ctx context.Context, actColl, h5Coll *mongo.Collection, mq event.Sender, scope, env string) error {
+// doSyncH5Style html5Style 不符合 h5Schema 的物料写入报告, 无法解析或类型不符时
+// allowInvalid 为 false 则不写入也不发送事件, 仅含未知字段的照常同步
+// ids 按物料 _id 过滤
+func doSyncH5Style(
+	ctx context.Context, actColl, h5Coll *mongo.Collection, mq events.Publisher, rep *report.Report, ids *idlist.Filter,
+	allowInvalid bool, st *executor.Stats, scope, env string,
+) error {
 	filter := primitive.M{
 		"type":      op.In([]int{2, 3}),
 		"isDeleted": false,
@@ -650,8 +765,30 @@ func doSyncH5Style(ctx context.Context, actColl, h5Coll *mongo.Collection, mq ev
 	log.Printf("sync %d activitys start \n", len(res))
 	for i := range res {
 		t := res[i]
-		style, attr := extractStyleAndAttribue(t.Extral.Html5Style)
 		actID := t.ID.Hex()
+		if !ids.Allow(scope, env, actID) {
+			continue
+		}
+
+		style, attr, problems := extractStyleAndAttribue(t.Extral.Html5Style)
+		for _, p := range problems {
+			rep.Fail(actColl, actID, report.Record{
+				Kind:  report.KindActivity,
+				Field: p.Path,
+				Class: report.ClassValidation,
+				Scope: scope,
+				Env:   env,
+			}, p)
+		}
+		if invalid := countInvalid(problems); invalid > 0 {
+			if !allowInvalid {
+				log.Printf("%d skip activity %s with invalid html5Style: %d problems\n", i, actID, invalid)
+				st.Failed++
+
+				continue
+			}
+			log.Printf("%d sync activity %s with invalid html5Style: %d problems\n", i, actID, invalid)
+		}
 		pro := H5Properties{}
 		err := h5Coll.FindOne(ctx, primitive.M{"activityID": actID}).Decode(&pro)
 		if err != nil {
@@ -663,12 +800,16 @@ func doSyncH5Style(ctx context.Context, actColl, h5Coll *mongo.Collection, mq ev
 		pro.Style = style
 		pro.ActivityID = t.ID.Hex()
 
-		_, err = h5Coll.UpdateByID(context.TODO(), pro.ID, op.Set(pro), options.Update().SetUpsert(true))
+		rec := report.Record{Kind: report.KindActivity, Scope: scope, Env: env}
+		_, err = store.Wrap(h5Coll).UpdateByID(context.TODO(), pro.ID, op.Set(pro), options.Update().SetUpsert(true))
 		if err != nil {
 			log.Printf("%d sync activity %s failed,error: %s \n", i, t.ID.Hex(), err)
-		} else {
-			log.Printf("%d sync prop %+v success \n", i, pro)
+			rep.Fail(actColl, actID, rec, err)
+			st.Failed++
+
+			continue
 		}
+		log.Printf("%d sync prop %+v success \n", i, pro)
 
 		msg := []*material.H5PropertiesWithActName{{
 			ID:        pro.ID.Hex(),
@@ -679,16 +820,82 @@ func doSyncH5Style(ctx context.Context, actColl, h5Coll *mongo.Collection, mq ev
 		}}
 
 		if err := material.SendOperitionPositionCreateMesssage(context.Background(), mq, scope, env, msg); err != nil {
-			log.Printf(err.Error())
+			log.Printf("%d send h5 properties of activity %s failed: %v\n", i, actID, err)
+			rep.Fail(actColl, actID, rec, fmt.Errorf("publish h5 properties: %w", err))
+			st.Failed++
+		} else {
+			st.Processed++
 		}
-
 	}
 
 	return nil
 }
 
-func extractStyleAndAttribue(extral string) (syle, attr string) {
+// h5Schema h5 物料 extral.html5Style 中 style、attribute 已知字段允许的 JSON 类型
+// 列表按现有图片、文字组件整理, 不一定完整, 因此不在列表中的字段只报告, 不影响同步
+// 值为 null 时视为未设置, 不校验类型
+var h5Schema = map[string]map[string][]string{
+	"style": {
+		"opacity":         {"number"},
+		"color":           {"string"},
+		"backgroundColor": {"string"},
+		"backgroundImage": {"string"},
+		"fontSize":        {"number", "string"},
+		"fontWeight":      {"number", "string"},
+		"fontFamily":      {"string"},
+		"lineHeight":      {"number", "string"},
+		"letterSpacing":   {"number"},
+		"textAlign":       {"string"},
+		"borderRadius":    {"number"},
+		"borderWidth":     {"number"},
+		"borderColor":     {"string"},
+		"borderStyle":     {"string"},
+		"transform":       {"string"},
+	},
+	"attribute": {
+		"zIndex":       {"number"},
+		"left":         {"number"},
+		"top":          {"number"},
+		"width":        {"number"},
+		"height":       {"number"},
+		"rotate":       {"number"},
+		"borderRadius": {"number"},
+		"posCenter":    {"boolean"},
+	},
+}
+
+// styleProblem html5Style 中不符合 h5Schema 的一处, Path 如 style.opacity
+type styleProblem struct {
+	Path   string
+	Reason string
+	// Unknown 字段不在 h5Schema 中, 只报告不阻止同步
+	Unknown bool
+}
+
+// countInvalid 阻止同步的问题数, 即无法解析及类型不符
+func countInvalid(problems []*styleProblem) int {
+	n := 0
+	for _, p := range problems {
+		if !p.Unknown {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (p *styleProblem) Error() string {
+	return p.Path + ": " + p.Reason
+}
+
+// extractStyleAndAttribue 拆分 extral.html5Style 并按 h5Schema 校验
+// JSON 无法解析时 style、attr 为空, 未知字段及类型不符的字段原样保留
+func extractStyleAndAttribue(extral string) (syle, attr string, problems []*styleProblem) {
 	// "{\"style\":{\"opacity\":1},\"attribute\":{\"zIndex\":0,\"left\":0,\"borderRadius\":10}}"
+	if extral == "" {
+		return "", "", nil
+	}
+
 	t := struct {
 		Style     interface{} `json:"style"`
 		Attribute interface{} `json:"attribute"`
@@ -696,9 +903,12 @@ func extractStyleAndAttribue(extral string) (syle, attr string) {
 
 	err := json.Unmarshal([]byte(extral), &t)
 	if err != nil {
-		return "", ""
+		return "", "", []*styleProblem{{Path: "extral.html5Style", Reason: err.Error()}}
 	}
 
+	problems = append(problems, checkH5Schema("style", t.Style)...)
+	problems = append(problems, checkH5Schema("attribute", t.Attribute)...)
+
 	if t.Style != nil {
 		sb, err := json.Marshal(t.Style)
 		if err == nil {
@@ -713,7 +923,72 @@ func extractStyleAndAttribue(extral string) (syle, attr string) {
 		}
 	}
 
-	return syle, attr
+	return syle, attr, problems
+}
+
+func checkH5Schema(name string, v interface{}) []*styleProblem {
+	if v == nil {
+		return nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return []*styleProblem{{Path: name, Reason: "is " + jsonType(v) + ", want object"}}
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	problems := []*styleProblem{}
+	schema := h5Schema[name]
+	for _, k := range keys {
+		types, known := schema[k]
+		if !known {
+			problems = append(problems, &styleProblem{Path: name + "." + k, Reason: "unknown key", Unknown: true})
+
+			continue
+		}
+
+		if typ := jsonType(m[k]); typ != "null" && !containsString(types, typ) {
+			problems = append(problems, &styleProblem{
+				Path:   name + "." + k,
+				Reason: fmt.Sprintf("is %s, want %s", typ, strings.Join(types, " or ")),
+			})
+		}
+	}
+
+	return problems
+}
+
+// jsonType json.Unmarshal 解析出的值对应的 JSON 类型
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+
+	return "object"
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
 }
 
 type H5Properties struct {
@@ -736,164 +1011,10 @@ type Activity struct {
 	Extral Exteral            `bson:"extral"`
 	Name   string             `bson:"name"`
 	Active bool               `bson:"active"`
+	// FieldDefCode 物料使用的组件, 如 image-t1、text-t1
+	FieldDefCode string `bson:"fieldDefCode"`
 }
 
 type Exteral struct {
 	Html5Style string `bson:"html5Style"`
 }
-
-func resetH5Attribute(ctx context.Context, client *mongo.Client) error {
-	log.Printf("==============run reset start =========== \n")
-	scope := map[string][]string{
-		"videobeats": {"prod", "operation", "dev", "qa", "pre"},
-		"camera360":  {"prod", "operation", "dev", "qa", "pre"},
-		"idphoto":    {"prod", "operation", "dev", "qa", "pre"},
-		"mix":        {"prod", "operation", "dev", "qa", "pre"},
-		"salad":      {"prod", "operation", "dev", "qa", "pre"},
-		"inface":     {"prod", "operation", "dev", "qa", "pre"},
-		"icc":        {"prod", "operation", "dev", "qa", "pre"},
-		// "icc": {"dev"},
-	}
-	dbActH5Names := make(map[string]string, 0)
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopEnv := fmt.Sprintf("%s_%s", sp, env)
-			actDbName := fmt.Sprintf("%s_%s", scopEnv, "operational-positions")
-			h5DbName := fmt.Sprintf("%s_%s", scopEnv, "h5")
-			dbActH5Names[actDbName] = h5DbName
-		}
-	}
-
-	for actDBName, h5DBName := range dbActH5Names {
-		log.Printf("==============run reset %s to %s start =========== \n", actDBName, h5DBName)
-		actColl := client.Database(actDBName).Collection("activity")
-		h5Coll := client.Database(h5DBName).Collection("properties")
-		if err := doResetAttribute(ctx, actColl, h5Coll); err != nil {
-			log.Printf("sync style %s to %s error :%v", actDBName, h5DBName, err)
-		}
-
-		log.Printf("==============run reset %s to %s end =========== \n", actDBName, h5DBName)
-	}
-
-	log.Printf("==============run reset finished =========== \n")
-
-	return nil
-}
-
-func doResetAttribute(ctx context.Context, actColl, h5Coll *mongo.Collection) error {
-	filter := primitive.M{
-		"type":         op.In([]int{2, 3}),
-		"isDeleted":    false,
-		"fieldDefCode": op.In([]string{"image-t1", "text-t1"}),
-	}
-
-	projection := primitive.M{
-		"_id":    1,
-		"rootID": 1,
-		"name":   1,
-		"extral": 1,
-		"pid":    1,
-		"scope":  1,
-		"type":   1,
-	}
-
-	cur, err := actColl.Find(ctx, filter, options.Find().SetProjection(projection))
-	if err != nil {
-		return err
-	}
-
-	var res []*Activity
-	if err := cur.All(ctx, &res); err != nil {
-		return err
-	}
-
-	log.Printf("reset attribute %d activitys start \n", len(res))
-	for i := range res {
-		t := res[i]
-		actID := t.ID.Hex()
-		pro := H5Properties{}
-		err := h5Coll.FindOne(ctx, primitive.M{"activityID": actID}).Decode(&pro)
-		if err != nil {
-			log.Println(err)
-
-			continue
-		}
-		hasUpdate, err := tryResetAttribute(&pro)
-		if err != nil {
-			log.Println(err)
-
-			continue
-		}
-
-		if !hasUpdate {
-			continue
-		}
-
-		_, err = h5Coll.UpdateByID(context.TODO(), pro.ID, op.Set(pro), options.Update().SetUpsert(true))
-		if err != nil {
-			log.Printf("%d reset activity %s failed,error: %s \n", i, t.ID.Hex(), err)
-		} else {
-			log.Printf("%d reset prop %+v success \n", i, pro)
-		}
-
-	}
-
-	return nil
-}
-
-func tryResetAttribute(pro *H5Properties) (bool, error) {
-	attriMap := map[string]interface{}{}
-	cond := false
-	if pro.Attribute == "" {
-		cond = true
-	} else {
-		if err := json.Unmarshal([]byte(pro.Attribute), &attriMap); err != nil {
-			return false, err
-		}
-
-		if _, ok := attriMap["posCenter"]; ok {
-			return false, nil
-		}
-
-		if v, ok := attriMap["zIndex"]; ok {
-			switch vv := v.(type) {
-			case int:
-				if vv < 1 {
-					cond = true
-				}
-			case int32:
-				if vv < 1 {
-					cond = true
-				}
-			case int64:
-				if vv < 1 {
-					cond = true
-				}
-			case float64:
-				if vv < 1 {
-					cond = true
-				}
-			}
-		} else {
-			cond = true
-		}
-	}
-
-	if !cond {
-		return false, nil
-	}
-
-	attriMap["posCenter"] = true
-
-	b, err := json.Marshal(attriMap)
-	if err != nil {
-		return false, err
-	}
-
-	uastr := string(b)
-	pro.Attribute = uastr
-
-	fmt.Printf("h5 %s protitis attribute has changeto  %s", pro.ActivityID, pro.Attribute)
-
-	return true, nil
-}
@@ -0,0 +1,170 @@
+package material
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/pinguo-icc/field-definitions/api"
+	"github.com/pinguo-icc/field-definitions/pkg/fieldvalue"
+	"github.com/pinguo-icc/go-lib/v2/dao"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditMaterials 按字段定义校验 operational_materials 中素材及分类的每个版本, 只读不写
+// 同步时不做校验(见 doSyncMaterial), 校验失败的数据在编辑器中才会报错, 需提前修正
+func AuditMaterials(ctx context.Context, client *mongo.Client, opt *Options) error {
+	spaces := spaceDBs(opt.Matrix)
+	units := make([]executor.Unit, 0, len(spaces))
+	for _, s := range spaces {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				db := client.Database(s.newMaterial)
+				fieldDB := dao.NewMongodbDAO(client.Database(s.field), "fields_definition")
+
+				a := &auditor{field: fieldDB, rep: opt.Report, st: st, scope: s.Scope, env: s.Env}
+				if err := auditCollection(ctx, a, dao.NewMongodbDAO(db, "material"), func(m *Material) auditDoc {
+					vs := make([]fieldvalue.Valuer, 0, len(m.Versions))
+					for i := range m.Versions {
+						vs = append(vs, &m.Versions[i])
+					}
+
+					return auditDoc{m.ID, m.TypeID, m.IsDeleted, FieldCategoryMaterial, report.KindMaterial, vs}
+				}); err != nil {
+					return fmt.Errorf("audit %s.material: %w", s.newMaterial, err)
+				}
+
+				if err := auditCollection(ctx, a, dao.NewMongodbDAO(db, "material_category"), func(c *Category) auditDoc {
+					vs := make([]fieldvalue.Valuer, 0, len(c.Versions))
+					for i := range c.Versions {
+						vs = append(vs, &c.Versions[i])
+					}
+
+					return auditDoc{c.ID, c.TypeID, c.IsDeleted, FieldCategoryMaterialCate, report.KindCategory, vs}
+				}); err != nil {
+					return fmt.Errorf("audit %s.material_category: %w", s.newMaterial, err)
+				}
+
+				return nil
+			},
+		})
+	}
+
+	runErr := opt.Run(ctx, units)
+	opt.Report.PrintFieldSummary(os.Stdout)
+
+	return runErr
+}
+
+// auditor 单个空间的校验状态
+type auditor struct {
+	field      dao.MongodbDAO
+	rep        *report.Report
+	st         *executor.Stats
+	scope, env string
+	// fdCache 字段定义缓存, 不存在的定义也缓存错误
+	fdCache map[string]*api.FieldsDefinition
+	fdErr   map[string]error
+}
+
+// auditDoc 待校验文档的公共部分
+type auditDoc struct {
+	id       primitive.ObjectID
+	typeID   string
+	deleted  bool
+	category FieldCategory
+	kind     string
+	versions []fieldvalue.Valuer
+}
+
+func (a *auditor) fieldDefine(ctx context.Context, typeID string, tp FieldCategory) (*api.FieldsDefinition, error) {
+	if a.fdCache == nil {
+		a.fdCache, a.fdErr = map[string]*api.FieldsDefinition{}, map[string]error{}
+	}
+
+	key := fmt.Sprintf("%s_%d", typeID, tp)
+	if fd, ok := a.fdCache[key]; ok {
+		return fd, a.fdErr[key]
+	}
+
+	fd, err := getFieldDefine(ctx, typeID, tp, a.field)
+	a.fdCache[key], a.fdErr[key] = fd, err
+
+	return fd, err
+}
+
+func auditCollection[T Material | Category](
+	ctx context.Context, a *auditor, mdb dao.MongodbDAO, doc func(*T) auditDoc,
+) error {
+	coll := mdb.Collection()
+	log.Printf("========= audit %s.%s start===========\n", coll.Database().Name(), coll.Name())
+
+	var after interface{}
+	for page := 1; ; page++ {
+		res, last, err := getSyncDatats[T](ctx, mdb, after, syncPageSize)
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 {
+			break
+		}
+
+		for _, v := range res {
+			d := doc(v)
+			if d.deleted {
+				continue
+			}
+
+			fd, err := a.fieldDefine(ctx, d.typeID, d.category)
+			if err != nil {
+				a.st.Failed++
+				a.rep.Fail(coll, d.id.Hex(), report.Record{
+					Kind: d.kind, Page: page, Class: report.ClassFieldDefinition, Scope: a.scope, Env: a.env,
+				}, fmt.Errorf("get field define by %s: %w", d.typeID, err))
+
+				continue
+			}
+
+			valid := true
+			for i, ver := range d.versions {
+				if err := fieldvalue.Validate(ver, fd); err != nil {
+					valid = false
+					a.rep.Fail(coll, d.id.Hex(), report.Record{
+						Kind: d.kind, Field: violationField(err), Page: page,
+						Class: report.ClassValidation, Scope: a.scope, Env: a.env,
+					}, fmt.Errorf("version %d: %w", i, err))
+				}
+			}
+			if !valid {
+				a.st.Failed++
+
+				continue
+			}
+			a.st.Processed++
+		}
+
+		after = last
+	}
+
+	return nil
+}
+
+// fieldCodeRe 从校验错误中提取字段 code, 如 "字段code=xiaoshu的数据类型需NumFloat类型"
+var fieldCodeRe = regexp.MustCompile(`code=([0-9A-Za-z_\-]+)`)
+
+// violationField 返回校验错误对应的字段 code, 无法识别(如本地化配置错误)时返回 "-"
+func violationField(err error) string {
+	m := fieldCodeRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "-"
+	}
+
+	return m[1]
+}
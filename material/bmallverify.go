@@ -0,0 +1,258 @@
+package material
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 映射检查发现的问题
+const (
+	// IssueDangling 映射的 opsID 指向不存在或已删除的素材
+	IssueDangling = "dangling"
+	// IssueStale 映射的素材已不再使用该 bmall ID, 或已由另一个素材使用
+	IssueStale = "stale"
+	// IssueMissing 素材使用的 bmall ID 没有映射
+	IssueMissing = "missing"
+	// IssueDuplicate 同一 bmall ID 被多个未删除的素材使用, 需人工处理
+	IssueDuplicate = "duplicate"
+)
+
+var mappingIssues = []string{IssueDangling, IssueStale, IssueMissing, IssueDuplicate}
+
+// VerifyMappingOptions 修复方式, 均为 false 时只检查
+type VerifyMappingOptions struct {
+	// Prune 删除没有素材使用的映射
+	Prune bool
+	// Repair 将映射指向唯一使用该 bmall ID 的素材, 缺少的映射补齐
+	Repair bool
+}
+
+// MappingResult VerifyBmallMapping 的检查结果
+type MappingResult struct {
+	Relationships int
+	// BmallIDs 未删除的素材使用的 bmall ID 数
+	BmallIDs int
+	Found    map[string]int
+	Fixed    map[string]int
+}
+
+// Print 按问题类型输出统计
+func (r *MappingResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d relationships, %d bmall ids used by materials\n", r.Relationships, r.BmallIDs)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ISSUE\tFOUND\tFIXED\n")
+	for _, is := range mappingIssues {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", is, r.Found[is], r.Fixed[is])
+	}
+	tw.Flush()
+}
+
+// mappingIssue 一个 bmall ID 的问题, fix 为修复操作(delete、update、insert), 为空时无法自动修复
+type mappingIssue struct {
+	issue   string
+	bmallID primitive.ObjectID
+	// target 修复后指向的素材
+	target string
+	fix    string
+	err    error
+}
+
+// VerifyBmallMapping 交叉检查 bmall.id_relationship 与目标空间的素材, 问题写入 opt.Report
+// 按 vo 删除或修复映射, dry-run 时只统计不写入
+func VerifyBmallMapping(
+	ctx context.Context, ops, bmall *mongo.Client, opt *Options, vo *VerifyMappingOptions,
+) (*MappingResult, error) {
+	spaces := opt.Matrix.Spaces()
+	if len(spaces) != 1 {
+		return nil, fmt.Errorf("bmall relationship must target exactly one scope/env, got %v", spaces)
+	}
+	sp := spaces[0]
+
+	opsColl := ops.Database(sp.DBName("operational_materials")).Collection("material")
+	relColl := bmall.Database("bmall").Collection("id_relationship")
+
+	deleted, claims, err := loadBmallClaims(ctx, opsColl)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := relColl.Find(ctx, primitive.M{}, options.Find().SetSort(primitive.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var rels []*RelationshipID
+	if err := cur.All(ctx, &rels); err != nil {
+		return nil, err
+	}
+
+	res := &MappingResult{
+		Relationships: len(rels),
+		BmallIDs:      len(claims),
+		Found:         map[string]int{},
+		Fixed:         map[string]int{},
+	}
+
+	for _, is := range checkMappings(rels, deleted, claims) {
+		res.Found[is.issue]++
+		rec := report.Record{Kind: report.KindMaterial, Field: is.issue, Class: report.ClassValidation, Scope: sp.Scope, Env: sp.Env}
+		if is.issue == IssueDuplicate {
+			rec.Class = report.ClassConflict
+		}
+		opt.Report.Fail(relColl, is.bmallID.Hex(), rec, is.err)
+
+		apply := (is.fix == "delete" && vo.Prune) || ((is.fix == "update" || is.fix == "insert") && vo.Repair)
+		if !apply {
+			continue
+		}
+
+		var err error
+		switch is.fix {
+		case "delete":
+			_, err = store.Wrap(relColl).DeleteOne(ctx, primitive.M{"_id": is.bmallID})
+		case "update":
+			_, err = store.Wrap(relColl).UpdateByID(ctx, is.bmallID, primitive.M{"$set": primitive.M{"opsID": is.target}})
+		case "insert":
+			_, err = store.Wrap(relColl).InsertOne(ctx, &RelationshipID{BmallID: is.bmallID, OpsID: is.target})
+		}
+		if err != nil {
+			log.Printf("%s relationship %s error: %s\n", is.fix, is.bmallID.Hex(), err)
+			opt.Report.Fail(relColl, is.bmallID.Hex(), report.Record{Kind: report.KindMaterial, Scope: sp.Scope, Env: sp.Env}, err)
+
+			continue
+		}
+
+		res.Fixed[is.issue]++
+		log.Printf("%s relationship %s => %q (%s)\n", is.fix, is.bmallID.Hex(), is.target, is.issue)
+	}
+
+	return res, nil
+}
+
+// loadBmallClaims 加载全部素材的删除状态, 及未删除素材使用的 bmall ID
+// 无效的 bmall ID 由 mapOfBmallAndOPS 报告, 这里忽略
+func loadBmallClaims(
+	ctx context.Context, coll *mongo.Collection,
+) (deleted map[string]bool, claims map[primitive.ObjectID][]string, err error) {
+	projection := primitive.M{"_id": 1, "isDeleted": 1}
+	for _, p := range BmallPlatforms {
+		projection["versions.custom."+p] = 1
+	}
+
+	cur, err := coll.Find(ctx, primitive.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+
+	deleted = map[string]bool{}
+	claims = map[primitive.ObjectID][]string{}
+	for cur.Next(ctx) {
+		m := new(Material)
+		if err := cur.Decode(m); err != nil {
+			return nil, nil, err
+		}
+
+		opsID := m.ID.Hex()
+		deleted[opsID] = m.IsDeleted
+		if m.IsDeleted {
+			continue
+		}
+
+		for _, p := range BmallPlatforms {
+			r, err := GenRelationshipID(m, p)
+			if err != nil || r == nil {
+				continue
+			}
+			if !containsString(claims[r.BmallID], opsID) {
+				claims[r.BmallID] = append(claims[r.BmallID], opsID)
+			}
+		}
+	}
+
+	return deleted, claims, cur.Err()
+}
+
+// checkMappings deleted 为全部素材的删除状态, claims 为未删除的素材使用的 bmall ID
+func checkMappings(
+	rels []*RelationshipID, deleted map[string]bool, claims map[primitive.ObjectID][]string,
+) []*mappingIssue {
+	issues := []*mappingIssue{}
+	mapped := map[primitive.ObjectID]bool{}
+	for _, r := range rels {
+		mapped[r.BmallID] = true
+		users := claims[r.BmallID]
+		if len(users) > 1 {
+			continue
+		}
+
+		is := &mappingIssue{bmallID: r.BmallID}
+		isDeleted, exists := deleted[r.OpsID]
+		switch {
+		case !exists || isDeleted:
+			is.issue = IssueDangling
+			is.err = fmt.Errorf("%s: material %s does not exist or is deleted", IssueDangling, r.OpsID)
+		case len(users) == 0:
+			is.issue = IssueStale
+			is.err = fmt.Errorf("%s: material %s no longer uses bmall id %s", IssueStale, r.OpsID, r.BmallID.Hex())
+		case users[0] != r.OpsID:
+			is.issue = IssueStale
+			is.err = fmt.Errorf("%s: bmall id %s is used by material %s instead of %s", IssueStale, r.BmallID.Hex(), users[0], r.OpsID)
+		default:
+			continue
+		}
+
+		if len(users) == 1 {
+			is.fix, is.target = "update", users[0]
+		} else {
+			is.fix = "delete"
+		}
+		issues = append(issues, is)
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(claims))
+	for id := range claims {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Hex() < ids[j].Hex()
+	})
+
+	for _, id := range ids {
+		users := claims[id]
+		switch {
+		case len(users) > 1:
+			issues = append(issues, &mappingIssue{
+				issue: IssueDuplicate, bmallID: id,
+				err: fmt.Errorf("%s: bmall id %s is used by materials %s", IssueDuplicate, id.Hex(), strings.Join(users, ", ")),
+			})
+		case !mapped[id]:
+			issues = append(issues, &mappingIssue{
+				issue: IssueMissing, bmallID: id, target: users[0], fix: "insert",
+				err: fmt.Errorf("%s: bmall id %s of material %s has no relationship", IssueMissing, id.Hex(), users[0]),
+			})
+		}
+	}
+
+	return issues
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,45 @@
+package material
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckMappings(t *testing.T) {
+	ok, dangling, stale, moved, missing, dup := primitive.NewObjectID(), primitive.NewObjectID(),
+		primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	rels := []*RelationshipID{
+		{BmallID: ok, OpsID: "a"},
+		{BmallID: dangling, OpsID: "gone"},
+		{BmallID: stale, OpsID: "b"},
+		{BmallID: moved, OpsID: "a"},
+		{BmallID: dup, OpsID: "a"},
+	}
+	deleted := map[string]bool{"a": false, "b": false, "c": false, "d": true}
+	claims := map[primitive.ObjectID][]string{
+		ok:      {"a"},
+		moved:   {"c"},
+		missing: {"b"},
+		dup:     {"a", "c"},
+	}
+
+	want := map[primitive.ObjectID][2]string{
+		dangling: {IssueDangling, "delete"},
+		stale:    {IssueStale, "delete"},
+		moved:    {IssueStale, "update"},
+		missing:  {IssueMissing, "insert"},
+		dup:      {IssueDuplicate, ""},
+	}
+
+	issues := checkMappings(rels, deleted, claims)
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d", len(issues), len(want))
+	}
+	for _, is := range issues {
+		if w := want[is.bmallID]; w != [2]string{is.issue, is.fix} {
+			t.Errorf("%s = %s/%s, want %v", is.bmallID.Hex(), is.issue, is.fix, w)
+		}
+	}
+}
@@ -2,217 +2,279 @@ package material
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/pinguo-icc/field-definitions/api"
-	"github.com/pinguo-icc/go-base/v2/event"
 	"github.com/pinguo-icc/go-lib/v2/dao"
 	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/checkpoint"
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-var (
-	scope = map[string][]string{
-		// "videobeats": {"prod", "operation", "dev", "qa", "pre"},
-		// "camera360":  {"prod", "operation", "dev", "qa", "pre"},
-		// "idphoto":    {"prod", "operation", "dev", "qa", "pre"},
-		// "mix":        {"prod", "operation", "dev", "qa", "pre"},
-		// "salad":      {"prod", "operation", "dev", "qa", "pre"},
-		// "inface":     {"prod", "operation", "dev", "qa", "pre"},
-		// "icc":        {"prod", "operation", "dev", "qa", "pre"},
-		// "april":      {"prod", "operation", "dev", "qa", "pre"},
-
-		// "videobeats": {"operation", "dev", "qa"},
-		"camera360": {"operation", "dev", "qa"},
-		"idphoto":   {"operation", "dev", "qa"},
-		"mix":       {"operation", "dev", "qa"},
-		"salad":     {"operation", "dev", "qa"},
-		"inface":    {"operation", "dev", "qa"},
-		"icc":       {"operation", "dev", "qa"},
-		"april":     {"operation", "dev", "qa"},
-		// "videobeats": {"operation"},
-	}
-	dbOldNewMap             = make(map[string]string, 0)
-	dbOldMFieldMap          = make(map[string]string, 0)
-	dbOldPosNewMap          = make(map[string]string, 0)
-	categorySyncErr         = make([]*SyncRecoder, 0, 100)
-	materialPositionSyncErr = make([]*SyncRecoder, 0, 100)
-	planPositionSyncErr     = make([]*SyncRecoder, 0, 100)
-	wg                      = sync.WaitGroup{}
-
-	excludeMaterialIDs = map[string]sets.String{
-		"videobeats_operation": sets.NewString(
-			"63a51a0fe99dc512b16e916b", "63bb8379b52c0f797ff1810f", "63bb83dcbdf592838b09a5d9",
-			"63a52418d72a90f71ab08c19", "63bbb554b52c0f797ff18112", "63bbb4fbb52c0f797ff18111",
-			"63bbb521bdf592838b09a5da", "63bb83a7b52c0f797ff18110", "63a523e9d72a90f71ab08c18",
-			"638416db53b5477a729ed71f", "6384176de1da649b2cbffedf", "638417287e366f7d1575903b",
-			"63745112e26f5473a81fd750", "637451938e9024f2dd0f428c", "63745166e26f5473a81fd751",
-			"6250134db404242ef7d2c218", "6305ea701fbbb4d2e0a0159e", "634d3053a2a8727060a9f0a8",
-			"634d30a5a2a8727060a9f0aa", "634d306ea2a8727060a9f0a9", "634d302718ff2d1020834187",
-			"62aa95005ae6ac2543af58a7", "6348d692a2a8727060a9f0a0", "62f9e6fb6a3e09b6bbc52bb6",
-			"626506c61ce80fa6e768f41b", "630c79f31fbbb4d2e0a015a6", "630c7a4b6a3e09b6bbc52bdd",
-			"619f288b9d06e8d7a7b537c0", "619f28729d06e8d7a7b537bf", "61e7c4dc253a4e1487ec9672",
-			"61e7c4ba253a4e1487ec9671", "62b27976f24cc3cfb0168e0a", "62b2795c5ae6ac2543af58bb",
-			"630327e06a3e09b6bbc52bbb", "62b405ecf24cc3cfb0168e10", "62b406945ae6ac2543af58bf",
-			"6306eb731fbbb4d2e0a015a1",
-		),
+// Options 按目标空间执行的迁移类命令的运行参数
+type Options struct {
+	// Matrix 本次执行的目标 scope/env
+	Matrix config.Matrix
+	// Checkpoints 同步进度, 为nil时不记录断点
+	Checkpoints *checkpoint.Store
+	// Concurrency 同时处理的 scope_env 数量
+	Concurrency int
+	// MQ --mq-profile 选择的 kafka 配置, 未指定时为nil
+	MQ *config.MQProfile
+	// EventsFile 不为空时事件写入该 NDJSON 文件, 不发送到 kafka
+	EventsFile string
+	// DeadLetters 发送 kafka 失败的事件写入死信, 为nil时不记录
+	DeadLetters *events.DeadLetters
+	// Report 记录处理失败的文档, 为nil时不记录
+	Report *report.Report
+	// Strict 旧数据的枚举值无法解析时拒绝同步整个文档, 否则转为零值并记录日志
+	Strict bool
+	// IDs 同步及计划覆盖类命令只处理允许的文档, 为nil时全部处理
+	IDs *idlist.Filter
+	// Batch 同步类命令的批量写入参数
+	Batch SyncBatch
+	// OnResults 不为nil时, 每次 Run 结束后回调各单元的执行结果, 用于按库记录台账
+	OnResults func(results []executor.Result)
+}
+
+// DefaultBatchSize 同步类命令默认每批写入的文档数
+const DefaultBatchSize = 100
+
+// SyncBatch 同步类命令的批量写入参数
+type SyncBatch struct {
+	// Size 每页读取并以一次 BulkWrite 写入的文档数, 不大于0时使用 DefaultBatchSize
+	Size int
+	// Ordered 遇到失败时停止写入本批剩余的文档, 默认继续写入
+	Ordered bool
+}
+
+func (b SyncBatch) size() int64 {
+	if b.Size <= 0 {
+		return DefaultBatchSize
 	}
-)
 
-func init() {
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopeEnv := fmt.Sprintf("%s_%s", sp, env)
-			oldMDBName := fmt.Sprintf("%s_%s", scopeEnv, "operations_materials")
-			newMDBName := fmt.Sprintf("%s_%s", scopeEnv, "operational_materials")
-			oldPosDbName := fmt.Sprintf("%s_%s", scopeEnv, "material-positions-v2")
-			fieldDbName := fmt.Sprintf("%s_%s", scopeEnv, "field-definitions")
-			dbOldNewMap[oldMDBName] = newMDBName
-			dbOldMFieldMap[oldMDBName] = fieldDbName
-			dbOldPosNewMap[oldPosDbName] = newMDBName
-		}
+	return int64(b.Size)
+}
+
+// Run 按 Concurrency 并发执行各空间的任务并输出汇总
+func (o *Options) Run(ctx context.Context, units []executor.Unit) error {
+	results := executor.Run(ctx, o.Concurrency, units)
+	executor.PrintSummary(os.Stdout, results)
+	if o.OnResults != nil {
+		o.OnResults(results)
 	}
+
+	return executor.Err(results)
 }
 
-func ClearMaterials(ctx context.Context, client *mongo.Client) error {
-	for _, new := range dbOldNewMap {
-		log.Printf("========= clear material %s start===========\n", new)
+// spaceDB 一个 scope_env 空间下迁移涉及的库
+type spaceDB struct {
+	config.Space
+	oldMaterial string // 旧素材库 operations_materials
+	newMaterial string // 新素材库 operational_materials
+	oldPosition string // 旧素材位置库 material-positions-v2
+	field       string // 字段表库 field-definitions
+}
 
-		newMDB := dao.NewMongodbDAO(client.Database(new), "material")
+func spaceDBs(m config.Matrix) []spaceDB {
+	res := []spaceDB{}
+	for _, sp := range m.Spaces() {
+		res = append(res, spaceDB{
+			Space:       sp,
+			oldMaterial: sp.DBName("operations_materials"),
+			newMaterial: sp.DBName("operational_materials"),
+			oldPosition: sp.DBName("material-positions-v2"),
+			field:       sp.DBName("field-definitions"),
+		})
+	}
 
-		if err := doClearMaterials(newMDB); err != nil {
-			log.Printf("clear material %s  error :%v", new, err)
-		}
+	return res
+}
+
+func ClearMaterials(ctx context.Context, client *mongo.Client, opt *Options) error {
+	units := []executor.Unit{}
+	for _, s := range spaceDBs(opt.Matrix) {
+		new := s.newMaterial
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(_ context.Context, st *executor.Stats) error {
+				log.Printf("========= clear material %s start===========\n", new)
+				newMDB := dao.NewMongodbDAO(client.Database(new), "material")
+				if err := doClearMaterials(newMDB, st); err != nil {
+					return fmt.Errorf("clear material %s: %w", new, err)
+				}
+				log.Printf("==========  clear material %s end \n", new)
 
-		log.Printf("==========  clear material %s end \n", new)
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func doClearMaterials(newM dao.MongodbDAO) error {
+func doClearMaterials(newM dao.MongodbDAO, st *executor.Stats) error {
 	t := time.UnixMilli(1669824000000)
 	filter := primitive.M{"versions.updatedAt": primitive.M{"$gt": t}}
-	de, err := newM.Collection().DeleteMany(context.Background(), filter)
+	de, err := store.Wrap(newM.Collection()).DeleteMany(context.Background(), filter)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println(de)
+	st.Processed += int(de.DeletedCount)
 
 	return nil
 }
 
-func SyncMaterials(ctx context.Context, client *mongo.Client) error {
-	mq, cancel := InitMQ()
-
-	for old, new := range dbOldNewMap {
-		wg.Add(1)
-		go func(o, n string) {
-			if err := materialSync(ctx, o, n, mq, client); err != nil {
-				fmt.Println(o, n, err.Error())
-			}
-		}(old, new)
+func SyncMaterials(ctx context.Context, client *mongo.Client, opt *Options) error {
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
 	}
+	defer cancel()
 
-	wg.Wait()
-	cancel()
+	units := []executor.Unit{}
+	for _, s := range spaceDBs(opt.Matrix) {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				return materialSync(ctx, s, mq, opt, client, st)
+			},
+		})
+	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func SyncUnityFontMaterials(ctx context.Context, client *mongo.Client) error {
-	mq, cancel := InitMQ()
+func SyncUnityFontMaterials(ctx context.Context, client *mongo.Client, opt *Options) error {
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
 	defer cancel()
 
-	dbName := fmt.Sprintf("%s_%s", "camera360_operation", "operational_materials")
-	dao := dao.NewMongodbDAO(client.Database(dbName), "material")
-	ctx = context.Background()
-	page := int32(1)
-	hasNext := true
-	for hasNext {
-		res, hn, err := getUnityFontData[Material](ctx, dao, page)
-		if err != nil {
-			return err
-		}
+	units := []executor.Unit{}
+	for _, sp := range opt.Matrix.Spaces() {
+		sp := sp
+		units = append(units, executor.Unit{
+			Name: sp.String(),
+			Run: func(_ context.Context, st *executor.Stats) error {
+				ctx := context.Background()
+				dbName := sp.DBName("operational_materials")
+				dao := dao.NewMongodbDAO(client.Database(dbName), "material")
+				page := int32(1)
+				hasNext := true
+				for hasNext {
+					res, hn, err := getUnityFontData[Material](ctx, dao, page)
+					if err != nil {
+						return err
+					}
 
-		for _, v := range res {
-			if v.ID.Hex() != "60e545c3c28b15d9486a2c4b" {
-				if err := sendMaterialCreateMessage(ctx, mq, "camera360", "operation", []*Material{v}); err != nil {
-					fmt.Printf("send material msg fail, err: %s", err.Error())
+					for _, v := range res {
+						if v.ID.Hex() != "60e545c3c28b15d9486a2c4b" && opt.IDs.Allow(sp.Scope, sp.Env, v.ID.Hex()) {
+							if err := sendMaterialCreateMessage(ctx, mq, sp.Scope, sp.Env, []*Material{v}); err != nil {
+								fmt.Printf("send material msg fail, err: %s", err.Error())
+								st.Failed++
+							} else {
+								st.Processed++
+							}
+						}
+					}
+
+					page++
+					hasNext = hn
 				}
-			}
-		}
 
-		page++
-		hasNext = hn
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func materialSync(ctx context.Context, old, new string, mq event.Sender, client *mongo.Client) error {
-	defer func() {
-		wg.Done()
-	}()
-
+func materialSync(
+	ctx context.Context, s spaceDB, mq events.Publisher, opt *Options, client *mongo.Client, st *executor.Stats,
+) error {
+	old, new := s.oldMaterial, s.newMaterial
 	log.Printf("========= sync material %s to %s start===========\n", old, new)
-	fdb, ok := dbOldMFieldMap[old]
-	if !ok {
-		log.Printf("get field db name by material name %s fail \n", old)
-
-		return fmt.Errorf("get field db name by material name")
-	}
 	oldMDB := dao.NewMongodbDAO(client.Database(old), "material")
 	newMDB := dao.NewMongodbDAO(client.Database(new), "material")
-	fieldDB := dao.NewMongodbDAO(client.Database(fdb), "fields_definition")
-
-	sps := strings.Split(old, "_")
-	if len(sps) < 2 {
-		return fmt.Errorf("cant get scope and env")
-	}
+	fieldDB := dao.NewMongodbDAO(client.Database(s.field), "fields_definition")
 
-	scope, env := sps[0], sps[1]
+	scope, env := s.Scope, s.Env
 
-	materialSyncRecoder, err := doSyncMaterial(ctx, oldMDB, newMDB, fieldDB, mq, scope, env)
+	err := doSyncMaterial(
+		ctx, oldMDB, newMDB, fieldDB, mq, opt.Checkpoints, opt.Report, opt.IDs, opt.Batch, opt.Strict, st, scope, env,
+	)
 	if err != nil {
-		log.Printf("sync style %s to %s error :%v", old, new, err)
+		return fmt.Errorf("sync material %s to %s: %w", old, new, err)
 	}
 
-	log.Printf("========= sync material %s to %s end=========== materialsSyncrecoder num %d\n", old, new, len(materialSyncRecoder))
-
-	if len(materialSyncRecoder) > 0 {
-		return wirteCvs(fmt.Sprintf("sync_material_%s_%s", scope, env), materialSyncRecoder)
-	}
+	log.Printf("========= sync material %s to %s end=========== failed num %d\n", old, new, st.Failed)
 
 	return nil
 }
 
-func isExcludeMaterialsID(scope, env, id string) bool {
-	key := scope + "_" + env
-	setIDs, ok := excludeMaterialIDs[key]
-	if !ok {
+// reportConvertErrors 记录转换错误, 返回文档是否被拒绝
+// 无法转换的字段记入报告, 文档仍会同步但不包含这些字段;
+// 被强制转为零值的字段在 strict 模式下拒绝整个文档, 否则保留零值并记录日志
+func reportConvertErrors(
+	rep *report.Report, coll *mongo.Collection, id string, rec report.Record, errs []error, strict bool,
+) bool {
+	for _, err := range errs {
+		r := rec
+		r.Class = report.ClassConversion
+		var ce *ConvertError
+		if errors.As(err, &ce) {
+			r.Field = ce.Code
+			if ce.Coerced {
+				if !strict {
+					log.Printf("coerce %s %s: %s", coll.Name(), id, err)
+
+					continue
+				}
+				r.Class = report.ClassCoercion
+			}
+		}
+
+		log.Printf("convert %s %s error: %s", coll.Name(), id, err)
+		rep.Fail(coll, id, r, err)
+	}
+
+	if !strict {
 		return false
 	}
+	for _, err := range errs {
+		var ce *ConvertError
+		if errors.As(err, &ce) && ce.Coerced {
+			return true
+		}
+	}
 
-	return setIDs.Has(id)
+	return false
 }
 
 func doSyncMaterial(
 	_ context.Context,
 	oldm, newM, field dao.MongodbDAO,
-	mq event.Sender, scope, env string,
-) ([]*SyncRecoder, error) {
-	syncRecoder := make([]*SyncRecoder, 0, 100)
+	mq events.Publisher, cp *checkpoint.Store, rep *report.Report, ids *idlist.Filter, batch SyncBatch, strict bool,
+	st *executor.Stats, scope, env string,
+) error {
 	//test
 	// ctx := context.Background()
 	// id := "62d910438f4854bca96eb5dd"
@@ -256,19 +318,29 @@ func doSyncMaterial(
 	// }
 
 	ctx := context.Background()
-	page := int32(1)
-	hasNext := true
-	for hasNext {
-		materilCreats := make([]*Material, 0)
-		res, hn, err := getSyncDatats[OldMaterial](ctx, oldm, page)
+	after, done, err := cp.Start(ctx, oldm.Collection())
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("%s.%s has been synced, skip", oldm.Collection().Database().Name(), oldm.Collection().Name())
+
+		return nil
+	}
+
+	for page := 1; ; page++ {
+		nms := make([]*Material, 0)
+		res, last, err := getSyncDatats[OldMaterial](ctx, oldm, after, batch.size())
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if len(res) == 0 {
+			break
 		}
 
 		fdCache := make(map[string]*api.FieldsDefinition)
 		for _, v := range res {
-			// 排除一些产品空间中的素材不同步
-			if !isExcludeMaterialsID(scope, env, v.ID.Hex()) {
+			if !ids.Allow(scope, env, v.ID.Hex()) {
 				continue
 			}
 
@@ -280,14 +352,10 @@ func doSyncMaterial(
 				fd, err = getFieldDefine(ctx, v.TypeID, FieldCategoryMaterial, field)
 				if err != nil {
 					log.Printf("get field define by %s error: %s", v.TypeID, err)
-					syncRecoder = append(syncRecoder, NewSyncRecoder(
-						oldm.Collection().Name(),
-						v.ID.Hex(),
-						err.Error(),
-						scope,
-						env,
-						0,
-					))
+					st.Failed++
+					rep.Fail(oldm.Collection(), v.ID.Hex(), report.Record{
+						Kind: report.KindMaterial, Page: page, Class: report.ClassFieldDefinition, Scope: scope, Env: env,
+					}, err)
 
 					continue
 				}
@@ -295,7 +363,14 @@ func doSyncMaterial(
 				fdCache[key] = fd
 			}
 
-			nm := v.convert(fd)
+			nm, cerrs := v.convert(fd)
+			if reportConvertErrors(rep, oldm.Collection(), v.ID.Hex(), report.Record{
+				Kind: report.KindMaterial, Page: page, Scope: scope, Env: env,
+			}, cerrs, strict) {
+				st.Failed++
+
+				continue
+			}
 			// 同步数据不校验 即使数据不正确也应该同步 用户下一次编辑的时候会提示错误修正即可
 			// var verr error
 			// for i := range nm.Versions {
@@ -316,44 +391,31 @@ func doSyncMaterial(
 			// 	continue
 			// }
 
-			count, _ := newM.Collection().CountDocuments(ctx, primitive.M{"_id": nm.ID})
-			if count > 0 {
-				if _, err := newM.Collection().DeleteOne(ctx, primitive.M{"_id": nm.ID}); err != nil {
-					fmt.Println(err)
-				}
-			}
-
-			if _, err := newM.Collection().UpdateOne(
-				ctx, primitive.M{"_id": nm.ID}, op.Set(nm),
-				options.Update().SetUpsert(true),
-			); err != nil {
-				log.Printf("insert %s error: %s", v.TypeID, err)
-				syncRecoder = append(syncRecoder, NewSyncRecoder(
-					oldm.Collection().Name(),
-					v.ID.Hex(),
-					err.Error(),
-					scope,
-					env,
-					0,
-				))
-
-				continue
-			}
-			materilCreats = append(materilCreats, nm)
+			nms = append(nms, nm)
 		}
+
+		materilCreats, serr := upsertPage(ctx, newM.Collection(), oldm.Collection(), nms, func(m *Material) primitive.ObjectID {
+			return m.ID
+		}, batch, rep, report.Record{Kind: report.KindMaterial, Page: page, Scope: scope, Env: env}, st)
 		if len(materilCreats) > 0 {
 			if err := sendMaterialCreateMessage(ctx, mq, scope, env, materilCreats); err != nil {
 				fmt.Printf("send material msg fail, err: %s", err.Error())
 			}
 		}
-		page++
-		hasNext = hn
+		if serr != nil {
+			return fmt.Errorf("page %d: %w", page, serr)
+		}
+
+		after = last
+		if err := cp.Save(ctx, oldm.Collection(), after); err != nil {
+			log.Printf("save checkpoint of %s error: %s", oldm.Collection().Name(), err)
+		}
 	}
 
-	return syncRecoder, nil
+	return cp.Done(ctx, oldm.Collection())
 }
 
-func DealwithMaterialCategoryParentID(ctx context.Context, client *mongo.Client) error {
+func DealwithMaterialCategoryParentID(ctx context.Context, client *mongo.Client, opt *Options) error {
 	// runCMD := func(scope, env, body, typeID, cateID string) error {
 	// 	params := make([]string, 0, 20)
 	// 	params = append(params, fmt.Sprintf("https://ops.camera360.com/v3/material-categories/%s/%s", typeID, cateID))
@@ -380,17 +442,22 @@ func DealwithMaterialCategoryParentID(ctx context.Context, client *mongo.Client)
 
 	// 	return nil
 	// }
-	send, cacel := InitMQ()
+	send, cacel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
 	defer cacel()
 
-	inner := func(db dao.MongodbDAO, scope, env string) error {
-		page := int32(1)
-		hasNext := true
-		for hasNext {
-			cates, hn, err := getSyncDatats[Category](context.Background(), db, page)
+	inner := func(db dao.MongodbDAO, st *executor.Stats, scope, env string) error {
+		var after interface{}
+		for {
+			cates, last, err := getSyncDatats[Category](context.Background(), db, after, syncPageSize)
 			if err != nil {
 				return err
 			}
+			if len(cates) == 0 {
+				break
+			}
 			updates := make([][]*Category, 0)
 			for i := range cates {
 				v := cates[i]
@@ -403,13 +470,15 @@ func DealwithMaterialCategoryParentID(ctx context.Context, client *mongo.Client)
 
 				updateC.Parent = ""
 
-				if _, err := db.Collection().UpdateOne(
+				if _, err := store.Wrap(db.Collection()).UpdateOne(
 					ctx, primitive.M{"_id": updateC.ID}, op.Set(&updateC),
 					options.Update().SetUpsert(true),
 				); err != nil {
 					fmt.Println(err)
+					st.Failed++
 					continue
 				}
+				st.Processed++
 
 				msgData := make([]*Category, 0, 2)
 				msgData = append(msgData, &originC, &updateC)
@@ -422,82 +491,103 @@ func DealwithMaterialCategoryParentID(ctx context.Context, client *mongo.Client)
 				}
 			}
 
-			page++
-			hasNext = hn
+			after = last
 		}
 		return nil
 	}
 
-	for _, new := range dbOldNewMap {
-		log.Printf("========= deal with material categorys  %s start===========\n", new)
-		newMDB := dao.NewMongodbDAO(client.Database(new), "material_category")
-		sps := strings.Split(new, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
-
-		scope, env := sps[0], sps[1]
-		if err := inner(newMDB, scope, env); err != nil {
-			return err
-		}
+	units := []executor.Unit{}
+	for _, s := range spaceDBs(opt.Matrix) {
+		new := s.newMaterial
+		scope, env := s.Scope, s.Env
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(_ context.Context, st *executor.Stats) error {
+				log.Printf("========= deal with material categorys  %s start===========\n", new)
+				newMDB := dao.NewMongodbDAO(client.Database(new), "material_category")
+				if err := inner(newMDB, st, scope, env); err != nil {
+					return err
+				}
+				log.Printf("========= deal with material categorys  %s end===========\n", new)
 
-		log.Printf("========= deal with material categorys  %s end===========\n", new)
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func SyncMaterialCategorys(ctx context.Context, client *mongo.Client) error {
-	mq, cancel := InitMQ()
+func SyncMaterialCategorys(ctx context.Context, client *mongo.Client, opt *Options) error {
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
 	defer cancel()
-	for old, new := range dbOldNewMap {
-		log.Printf("========= sync material categorys %s to %s start===========\n", old, new)
-		fdb, ok := dbOldMFieldMap[old]
-		if !ok {
-			log.Printf("get field db name by material category name %s fail \n", old)
-
-			return fmt.Errorf("get field db name by material  category name")
-		}
-		oldMDB := dao.NewMongodbDAO(client.Database(old), "material_category")
-		newMDB := dao.NewMongodbDAO(client.Database(new), "material_category")
-		fieldDB := dao.NewMongodbDAO(client.Database(fdb), "fields_definition")
-
-		sps := strings.Split(old, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
-
-		scope, env := sps[0], sps[1]
 
-		if err := doSyncMaterialCategory(ctx, oldMDB, newMDB, fieldDB, mq, scope, env); err != nil {
-			log.Printf("sync category  %s to %s error :%v", old, new, err)
-		}
+	spaces := spaceDBs(opt.Matrix)
+	units := make([]executor.Unit, 0, len(spaces))
+	for _, s := range spaces {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				old, new := s.oldMaterial, s.newMaterial
+				log.Printf("========= sync material categorys %s to %s start===========\n", old, new)
+				oldMDB := dao.NewMongodbDAO(client.Database(old), "material_category")
+				newMDB := dao.NewMongodbDAO(client.Database(new), "material_category")
+				fieldDB := dao.NewMongodbDAO(client.Database(s.field), "fields_definition")
+
+				err := doSyncMaterialCategory(
+					ctx, oldMDB, newMDB, fieldDB, mq, opt.Checkpoints, opt.Report, opt.IDs, opt.Batch, opt.Strict,
+					st, s.Scope, s.Env,
+				)
+				if err != nil {
+					return fmt.Errorf("sync category %s to %s: %w", old, new, err)
+				}
 
-		log.Printf("========== run sync %s to %s end \n", old, new)
-	}
+				log.Printf("========== run sync %s to %s end \n", old, new)
 
-	if len(categorySyncErr) > 0 {
-		return wirteCvs("sync_material_category", categorySyncErr)
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
 func doSyncMaterialCategory(
-	_ context.Context, oldm, newM, field dao.MongodbDAO, mq event.Sender, scope, env string,
+	_ context.Context, oldm, newM, field dao.MongodbDAO,
+	mq events.Publisher, cp *checkpoint.Store, rep *report.Report, ids *idlist.Filter, batch SyncBatch, strict bool,
+	st *executor.Stats, scope, env string,
 ) error {
 	ctx := context.Background()
-	page := int32(1)
-	hasNext := true
-	for hasNext {
-		creats := make([]*Category, 0)
-		res, hn, err := getSyncDatats[OldCategory](ctx, oldm, page)
+	after, done, err := cp.Start(ctx, oldm.Collection())
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("%s.%s has been synced, skip", oldm.Collection().Database().Name(), oldm.Collection().Name())
+
+		return nil
+	}
+
+	for page := 1; ; page++ {
+		nms := make([]*Category, 0)
+		res, last, err := getSyncDatats[OldCategory](ctx, oldm, after, batch.size())
 		if err != nil {
 			return err
 		}
+		if len(res) == 0 {
+			break
+		}
 
 		fdCache := make(map[string]*api.FieldsDefinition)
 		for _, v := range res {
+			if !ids.Allow(scope, env, v.ID.Hex()) {
+				continue
+			}
+
 			var fd *api.FieldsDefinition
 			key := fmt.Sprintf("%s_%d", v.TypeID, FieldCategoryMaterialCate)
 			if fdc, ok := fdCache[key]; ok {
@@ -506,14 +596,10 @@ func doSyncMaterialCategory(
 				fd, err = getFieldDefine(ctx, v.TypeID, FieldCategoryMaterialCate, field)
 				if err != nil {
 					log.Printf("get field define by %s error: %s", v.TypeID, err)
-					categorySyncErr = append(categorySyncErr, NewSyncRecoder(
-						oldm.Collection().Name(),
-						v.ID.Hex(),
-						err.Error(),
-						scope,
-						env,
-						0,
-					))
+					st.Failed++
+					rep.Fail(oldm.Collection(), v.ID.Hex(), report.Record{
+						Kind: report.KindCategory, Page: page, Class: report.ClassFieldDefinition, Scope: scope, Env: env,
+					}, err)
 
 					continue
 				}
@@ -522,43 +608,38 @@ func doSyncMaterialCategory(
 			}
 			// b, _ := json.Marshal(v)
 			// fmt.Println(string(b))
-			nm := v.convert(fd)
-			// b, _ = json.Marshal(nm)
-			// fmt.Println(string(b))
-			count, _ := newM.Collection().CountDocuments(ctx, primitive.M{"_id": nm.ID})
-			if count > 0 {
-				if _, err := newM.Collection().DeleteOne(ctx, primitive.M{"_id": nm.ID}); err != nil {
-					fmt.Println(err)
-				}
-			}
-			if _, err := newM.Collection().UpdateOne(
-				ctx, primitive.M{"_id": nm.ID}, op.Set(nm),
-				options.Update().SetUpsert(true),
-			); err != nil {
-				log.Printf("insert %s error: %s", v.TypeID, err)
-				categorySyncErr = append(categorySyncErr, NewSyncRecoder(
-					oldm.Collection().Name(),
-					v.ID.Hex(),
-					err.Error(),
-					scope,
-					env,
-					0,
-				))
+			nm, cerrs := v.convert(fd)
+			if reportConvertErrors(rep, oldm.Collection(), v.ID.Hex(), report.Record{
+				Kind: report.KindCategory, Page: page, Scope: scope, Env: env,
+			}, cerrs, strict) {
+				st.Failed++
 
 				continue
 			}
-			creats = append(creats, nm)
+			// b, _ = json.Marshal(nm)
+			// fmt.Println(string(b))
+			nms = append(nms, nm)
 		}
+
+		creats, serr := upsertPage(ctx, newM.Collection(), oldm.Collection(), nms, func(c *Category) primitive.ObjectID {
+			return c.ID
+		}, batch, rep, report.Record{Kind: report.KindCategory, Page: page, Scope: scope, Env: env}, st)
 		if len(creats) > 0 {
 			if err := sendCategoryCreateMessage(ctx, mq, scope, env, creats); err != nil {
 				fmt.Printf("send material msg fail, err: %s", err.Error())
 			}
 		}
-		page++
-		hasNext = hn
+		if serr != nil {
+			return fmt.Errorf("page %d: %w", page, serr)
+		}
+
+		after = last
+		if err := cp.Save(ctx, oldm.Collection(), after); err != nil {
+			log.Printf("save checkpoint of %s error: %s", oldm.Collection().Name(), err)
+		}
 	}
 
-	return nil
+	return cp.Done(ctx, oldm.Collection())
 }
 
 func getUnityFontData[T Material](
@@ -581,23 +662,72 @@ func getUnityFontData[T Material](
 	return res, total > page, err
 }
 
+// syncPageSize 同步时每页读取的文档数
+const syncPageSize = 10
+
+// getSyncDatats 按 _id 倒序分页读取 limit 条, after 为上一页最后一条的 _id, 为nil时从头开始
+// 返回本页数据及最后一条的 _id, 数据为空表示已读完
 func getSyncDatats[T OldCategory | OldMaterial | MaterialPosition | Plan | Material | Category](
-	ctx context.Context, mdb dao.MongodbDAO, page int32,
-) ([]*T, bool, error) {
-	opts := &FindOptions{}
-	opts.Pagination().SetPage(page).SetPageSize(10)
-	res := []*T{}
-	total := int32(0)
-	err := mdb.Find(ctx, &res, opts)
+	ctx context.Context, mdb dao.MongodbDAO, after interface{}, limit int64,
+) ([]*T, interface{}, error) {
+	filter := primitive.M{}
+	if after != nil {
+		filter["_id"] = primitive.M{"$lt": after}
+	}
+
+	cur, err := mdb.Collection().Find(
+		ctx, filter,
+		options.Find().SetSort(primitive.D{{Key: "_id", Value: -1}}).SetLimit(limit),
+	)
 	if err != nil {
-		return nil, false, err
+		return nil, nil, err
 	}
+	defer cur.Close(ctx)
 
-	if opts.Pagination() != nil {
-		total = opts.Pagination().TotalPage
+	res := []*T{}
+	var last interface{}
+	for cur.Next(ctx) {
+		t := new(T)
+		if err := cur.Decode(t); err != nil {
+			return nil, nil, err
+		}
+		// 直接从原始文档取 _id, 不依赖各结构体的 tag
+		if err := cur.Current.Lookup("_id").Unmarshal(&last); err != nil {
+			return nil, nil, err
+		}
+		res = append(res, t)
 	}
 
-	return res, total > page, err
+	return res, last, cur.Err()
+}
+
+// upsertPage 以一次 BulkWrite 按 _id 替换(不存在时插入)本页的文档, 失败的文档计入 st 并写入报告
+// 返回写入成功的文档, 用于发送事件; 返回的错误为写入后的快照失败, 此时文档已写入
+func upsertPage[T any](
+	ctx context.Context, coll, src *mongo.Collection, docs []*T, id func(*T) primitive.ObjectID,
+	batch SyncBatch, rep *report.Report, rec report.Record, st *executor.Stats,
+) ([]*T, error) {
+	rs := make([]store.Replacement, 0, len(docs))
+	for _, d := range docs {
+		rs = append(rs, store.Replacement{ID: id(d), Doc: d})
+	}
+	errs, serr := store.Wrap(coll).ReplaceMany(ctx, rs, batch.Ordered)
+
+	written := make([]*T, 0, len(docs))
+	for i, d := range docs {
+		if errs[i] != nil {
+			log.Printf("upsert %s %s error: %s", coll.Name(), id(d).Hex(), errs[i])
+			st.Failed++
+			rep.Fail(src, id(d).Hex(), rec, errs[i])
+
+			continue
+		}
+
+		st.Processed++
+		written = append(written, d)
+	}
+
+	return written, serr
 }
 
 func getSingleMaterial(ctx context.Context, id string, mdb dao.MongodbDAO) (*OldMaterial, error) {
@@ -636,114 +766,78 @@ func getFieldDefine(ctx context.Context, fid string, tp FieldCategory, mdb dao.M
 	return apiFieldsDefinition(doc), nil
 }
 
-func wirteCvs(app string, recoder []*SyncRecoder) error {
-	csvFile := fmt.Sprintf("./%s_%d.csv", app, time.Now().Unix())
-	fh, err := os.OpenFile(csvFile, os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-
-	fw := csv.NewWriter(fh)
-	rows := make([][]string, 0, len(recoder))
-	for _, v := range recoder {
-		rowCSV := make([]string, 0, 5)
-		rowCSV = append(rowCSV, v.DBFullColl)
-		rowCSV = append(rowCSV, v.ID)
-		if v.Type == 0 {
-			rowCSV = append(rowCSV, "material")
-		} else {
-			rowCSV = append(rowCSV, "category")
-		}
-		rowCSV = append(rowCSV, v.Err)
-		rowCSV = append(rowCSV, v.Scope)
-		rowCSV = append(rowCSV, v.Env)
-		rows = append(rows, rowCSV)
-	}
-
-	return fw.WriteAll(rows)
-}
-
-func ResetMaterialCategoryVersionID(ctx context.Context, client *mongo.Client) error {
+func ResetMaterialCategoryVersionID(ctx context.Context, client *mongo.Client, opt *Options) error {
 	log.Printf("==============run sync start =========== \n")
 	//mq, cancel := material.InitMQ()
 
-	scope := map[string][]string{
-		"videobeats": {"prod", "dev", "qa", "pre"},
-		"camera360":  {"prod", "dev", "qa", "pre"},
-		"idphoto":    {"prod", "dev", "qa", "pre"},
-		"mix":        {"prod", "dev", "qa", "pre"},
-		"salad":      {"prod", "dev", "qa", "pre"},
-		"inface":     {"prod", "dev", "qa", "pre"},
-		"april":      {"prod", "dev", "qa", "pre"},
-		"icc":        {"prod", "dev", "qa", "pre"},
-		// "icc": {"operation"},
-	}
-
-	dbs := make(map[string]dao.MongodbDAO)
-	for sp, envs := range scope {
-		for _, env := range envs {
-			scopeEnv := fmt.Sprintf("%s_%s", sp, env)
-			actSpaceName := fmt.Sprintf("%s_%s", scopeEnv, "operational_materials")
-			dbs[actSpaceName] = dao.NewMongodbDAO(client.Database(actSpaceName), "material_category")
-		}
+	// operation 空间为同步源, 不作为目标
+	units := []executor.Unit{}
+	for _, sp := range opt.Matrix.Without("operation").Spaces() {
+		sp := sp
+		units = append(units, executor.Unit{
+			Name: sp.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				operationDBName := fmt.Sprintf("%s_operation_%s", sp.Scope, "operational_materials")
+				operationColl := client.Database(operationDBName).Collection("material_category")
+				coll := client.Database(sp.DBName("operational_materials")).Collection("material_category")
+				if err := doResetCategoryVersionID(ctx, operationColl, coll, st, sp.Scope, sp.Env); err != nil {
+					return fmt.Errorf("reset materials cate version id of %s: %w", coll.Database().Name(), err)
+				}
+
+				return nil
+			},
+		})
 	}
 
-	for sp, envs := range scope {
-		operationDBName := fmt.Sprintf("%s_operation_%s", sp, "operational_materials")
-		operationDB := dao.NewMongodbDAO(client.Database(operationDBName), "material_category")
-		cur, err := operationDB.Collection().Find(ctx, primitive.M{})
-		if err != nil {
-			return err
-		}
-		var res []*Category
-		if err := cur.All(ctx, &res); err != nil {
-			return err
-		}
+	//cancel()
 
-		for i := range res {
-			t := res[i]
-			for _, env := range envs {
-				log.Printf("start sync opration materials cate %s %s %s materials cate\n", t.ID.Hex(), sp, env)
-				scopeEnv := fmt.Sprintf("%s_%s", sp, env)
-				spaceName := fmt.Sprintf("%s_%s", scopeEnv, "operational_materials")
-				// spaceActName := fmt.Sprintf("%s_%s", scopeEnv, "operational-positions")
-
-				coll := dbs[spaceName]
-				//actColl := actDbs[spaceActName]
-				category := new(Category)
-				err = coll.Collection().FindOne(ctx, primitive.M{"_id": t.ID}).Decode(category)
-				if err != nil {
-					if err == mongo.ErrNoDocuments {
-						log.Printf("end sync opration materials cate  %s %s %s with not found \n ", t.ID.Hex(), sp, env)
+	return opt.Run(ctx, units)
+}
 
-						continue
-					}
+// doResetCategoryVersionID 将 coll 中分类的默认版本 ID 重置为 operation 空间中同一分类的版本 ID, 写入失败计入 st.Failed
+func doResetCategoryVersionID(ctx context.Context, operationColl, coll *mongo.Collection, st *executor.Stats, sp, env string) error {
+	cur, err := operationColl.Find(ctx, primitive.M{})
+	if err != nil {
+		return err
+	}
+	var res []*Category
+	if err := cur.All(ctx, &res); err != nil {
+		return err
+	}
 
-					return err
-				}
+	for i := range res {
+		t := res[i]
+		log.Printf("start sync opration materials cate %s %s %s materials cate\n", t.ID.Hex(), sp, env)
+		category := new(Category)
+		err = coll.FindOne(ctx, primitive.M{"_id": t.ID}).Decode(category)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				log.Printf("end sync opration materials cate  %s %s %s with not found \n ", t.ID.Hex(), sp, env)
 
-				if category.Versions[0].VersionID == t.Versions[0].VersionID {
-					log.Printf("end sync opration materials cate %s %s %s with the same as \n ", t.ID.Hex(), sp, env)
+				continue
+			}
 
-					continue
-				}
+			return err
+		}
 
-				category.Versions[0].VersionID = t.Versions[0].VersionID
-				_, err = coll.Collection().UpdateByID(context.TODO(), t.ID, op.Set(t), options.Update().SetUpsert(true))
-				if err != nil {
-					log.Printf("%d reset materials cate %s failed,error: %s \n", i, t.ID.Hex(), err)
-				} else {
-					log.Printf("%d reset materials cate %+v success \n", i, t)
-				}
+		if category.Versions[0].VersionID == t.Versions[0].VersionID {
+			log.Printf("end sync opration materials cate %s %s %s with the same as \n ", t.ID.Hex(), sp, env)
 
-				log.Printf("end sync opration materials cate %s %s %s h5\n ", t.ID.Hex(), sp, env)
-			}
+			continue
+		}
 
+		category.Versions[0].VersionID = t.Versions[0].VersionID
+		_, err = store.Wrap(coll).UpdateByID(ctx, t.ID, op.Set(category), options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("%d reset materials cate %s failed,error: %s \n", i, t.ID.Hex(), err)
+			st.Failed++
+		} else {
+			log.Printf("%d reset materials cate %+v success \n", i, t)
+			st.Processed++
 		}
 
+		log.Printf("end sync opration materials cate %s %s %s h5\n ", t.ID.Hex(), sp, env)
 	}
 
-	//cancel()
-
 	return nil
 }
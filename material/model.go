@@ -2,6 +2,7 @@ package material
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -14,6 +15,8 @@ import (
 	"github.com/pinguo-icc/go-lib/v2/dao"
 	ldao "github.com/pinguo-icc/go-lib/v2/dao"
 	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/store"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -29,12 +32,39 @@ type CustomAttribute struct {
 	Value     *FieldValue `bson:"value"`
 }
 
-func (ca *CustomAttribute) convert(field *api.Field) *fieldvalue.FieldValue {
+func (ca *CustomAttribute) convert(field *api.Field) (*fieldvalue.FieldValue, error) {
 	if ca == nil || ca.Value == nil {
-		return nil
+		return nil, nil
+	}
+	if field == nil {
+		return nil, &ConvertError{Code: ca.Code, Reason: "not found in the fields definition"}
+	}
+
+	fv, err := ca.Value.convert(field)
+	if err != nil {
+		var ce *CoercionError
+		ok := errors.As(err, &ce)
+		if !ok {
+			fv = nil
+		}
+
+		return fv, &ConvertError{Code: ca.Code, Type: field.CustomizedFieldType, Reason: err.Error(), Coerced: ok}
 	}
 
-	return ca.Value.convert(field)
+	return fv, nil
+}
+
+// ConvertError 旧数据中无法转换为字段表定义类型的字段值
+type ConvertError struct {
+	Code   string
+	Type   api.CustomizedFieldType
+	Reason string
+	// Coerced 值已被强制转换并保留, 否则该字段被丢弃
+	Coerced bool
+}
+
+func (e *ConvertError) Error() string {
+	return fmt.Sprintf("field code=%s (customized type %d) cannot be converted: %s", e.Code, e.Type, e.Reason)
 }
 
 // NumberRangeValue 数值范围
@@ -127,65 +157,97 @@ func (ev *EnumValue) ConvertToMutilString() []string {
 	return ev.Value
 }
 
-func (ev *EnumValue) ConvertToSingleInt() *int64 {
+// ConvertToSingleInt 无法解析的值转为0, 同时返回错误
+func (ev *EnumValue) ConvertToSingleInt() (*int64, error) {
 	if ev == nil || len(ev.Value) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	v := ev.Value[0]
-	res, _ := strconv.ParseInt(v, 10, 64)
+	res, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return &res, &CoercionError{Value: v, Want: "int"}
+	}
 
-	return &res
+	return &res, nil
 }
 
-func (ev *EnumValue) ConvertToMutilInt() []int64 {
+// ConvertToMutilInt 无法解析的值转为0, 同时返回错误
+func (ev *EnumValue) ConvertToMutilInt() ([]int64, error) {
 	if ev == nil {
-		return []int64{}
+		return []int64{}, nil
 	}
 
+	var cerr error
 	res := make([]int64, 0, len(ev.Value))
 	for _, v := range ev.Value {
-		cv, _ := strconv.ParseInt(v, 10, 64)
+		cv, err := strconv.ParseInt(v, 10, 64)
+		if err != nil && cerr == nil {
+			cerr = &CoercionError{Value: v, Want: "int"}
+		}
 		res = append(res, cv)
 	}
 
-	return res
+	return res, cerr
 }
 
-func (ev *EnumValue) ConvertToSingleFloat() *float64 {
+// ConvertToSingleFloat 无法解析的值转为0, 同时返回错误
+func (ev *EnumValue) ConvertToSingleFloat() (*float64, error) {
 	if ev == nil || len(ev.Value) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	v := ev.Value[0]
-	res, _ := strconv.ParseFloat(v, 10)
+	res, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return &res, &CoercionError{Value: v, Want: "float"}
+	}
 
-	return &res
+	return &res, nil
 }
 
-func (ev *EnumValue) ConvertToMutilFloat() []float64 {
+// ConvertToMutilFloat 无法解析的值转为0, 同时返回错误
+func (ev *EnumValue) ConvertToMutilFloat() ([]float64, error) {
 	if ev == nil {
-		return []float64{}
+		return []float64{}, nil
 	}
 
+	var cerr error
 	res := make([]float64, 0, len(ev.Value))
 	for _, v := range ev.Value {
-		cv, _ := strconv.ParseFloat(v, 10)
+		cv, err := strconv.ParseFloat(v, 64)
+		if err != nil && cerr == nil {
+			cerr = &CoercionError{Value: v, Want: "float"}
+		}
 		res = append(res, cv)
 	}
 
-	return res
+	return res, cerr
 }
 
-func (ev *EnumValue) ConvertToBool() *bool {
+// ConvertToBool 无法解析的值转为false, 同时返回错误
+func (ev *EnumValue) ConvertToBool() (*bool, error) {
 	if ev == nil || len(ev.Value) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	v := ev.Value[0]
-	res, _ := strconv.ParseBool(v)
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return &res, &CoercionError{Value: v, Want: "bool"}
+	}
 
-	return &res
+	return &res, nil
+}
+
+// CoercionError 旧数据的值无法解析, 已被强制转为零值
+type CoercionError struct {
+	Value string
+	Want  string
+}
+
+func (e *CoercionError) Error() string {
+	return fmt.Sprintf("enum value %q is not a valid %s, coerced to the zero value", e.Value, e.Want)
 }
 
 // FileValue 文件
@@ -347,9 +409,9 @@ type LocalizeAttribute struct {
 	CustomAttributes []*CustomAttribute `bson:"customAttributes"`
 }
 
-func (la *LocalizeAttribute) convert(fd *api.FieldsDefinition) *fieldvalue.Localize {
+func (la *LocalizeAttribute) convert(fd *api.FieldsDefinition) (*fieldvalue.Localize, []error) {
 	if la == nil {
-		return nil
+		return nil, nil
 	}
 
 	res := &fieldvalue.Localize{}
@@ -366,18 +428,15 @@ func (la *LocalizeAttribute) convert(fd *api.FieldsDefinition) *fieldvalue.Local
 			}
 		}
 
-		if v.Code == pkg.Code_ClientLocale {
-			if v.Value == nil {
-				res.ClientLocale = nil
-			}
-
+		if v.Code == pkg.Code_ClientLocale && v.Value != nil {
 			res.ClientLocale = v.Value.NameAndValuesValue.convert()
 		}
 	}
 
-	res.Custom = convertCustomAttribuite(fd, la.CustomAttributes)
+	var errs []error
+	res.Custom, errs = convertCustomAttribuite(fd, la.CustomAttributes)
 
-	return res
+	return res, errs
 }
 
 // NameAndValuesValue Key=>Values 键值
@@ -474,112 +533,283 @@ type FieldValue struct {
 	ColorValue         *ColorValue         `bson:"colorValue,omitempty" json:"color,omitempty"`
 }
 
-func (fv *FieldValue) convert(fd *api.Field) *fieldvalue.FieldValue {
+// present 返回已设置的旧字段值类型, 用于错误提示
+func (fv *FieldValue) present() []string {
+	res := []string{}
+	for _, v := range []struct {
+		name string
+		set  bool
+	}{
+		{"textValue", fv.TextValue != nil},
+		{"numberValue", fv.NumberValue != nil},
+		{"numberRangeValue", fv.NumberRangeValue != nil},
+		{"dateTimeValue", fv.DateTimeValue != nil},
+		{"enumValue", fv.EnumValue != nil},
+		{"fileValue", fv.FileValue != nil},
+		{"imageValue", fv.ImageValue != nil},
+		{"videoValue", fv.VideoValue != nil},
+		{"referenceValue", fv.ReferenceValue != nil},
+		{"nameAndValuesValue", len(fv.NameAndValuesValue) > 0},
+		{"versionValue", fv.VersionValue != nil},
+		{"tagValue", fv.TagValue != nil},
+		{"numberInt", fv.NumberIntValue != nil},
+		{"numberFloat", fv.NumberFloatValue != nil},
+		{"colorValue", fv.ColorValue != nil},
+	} {
+		if v.set {
+			res = append(res, v.name)
+		}
+	}
+
+	return res
+}
+
+// convert 按字段表定义的类型转换, 兼容旧数据中的其他表示方式
+// 字段值为空时返回nil, 无法转换时返回错误; 值被强制转为零值时同时返回结果及 *CoercionError
+func (fv *FieldValue) convert(fd *api.Field) (*fieldvalue.FieldValue, error) {
 	if fv == nil || fd == nil {
-		return nil
+		return nil, nil
+	}
+
+	present := fv.present()
+	if len(present) == 0 {
+		return nil, nil
+	}
+	unexpected := func(want string) error {
+		return fmt.Errorf("want %s, got %s", want, strings.Join(present, ","))
 	}
 
 	switch fd.CustomizedFieldType {
 	case api.CustomizedFieldType_Text:
+		if fv.TextValue == nil {
+			return nil, unexpected("textValue")
+		}
+
 		return &fieldvalue.FieldValue{
 			Text: fv.TextValue.convert(),
-		}
+		}, nil
 	case api.CustomizedFieldType_Number:
-		if fd.GetNumber().Type == api.CustomizedNumberConfig_Int {
-			return &fieldvalue.FieldValue{
-				NumberInt: fv.NumberIntValue.convert(),
-			}
-		}
-		if fd.GetNumber().Type == api.CustomizedNumberConfig_Float {
-			return &fieldvalue.FieldValue{
-				NumberFloat: fv.NumberFloatValue.convert(),
-			}
+		return fv.convertNumber(fd.GetNumber().GetType(), unexpected)
+	case api.CustomizedFieldType_NumberRange:
+		if fv.NumberRangeValue == nil {
+			return nil, unexpected("numberRangeValue")
 		}
 
-		return nil
-	case api.CustomizedFieldType_NumberRange:
 		return &fieldvalue.FieldValue{
 			NumberFloatArray: fv.NumberRangeValue.convert(),
-		}
+		}, nil
 	case api.CustomizedFieldType_Datetime:
-		if fd.GetDatetime().Type == api.CustomizedDatetimeConfig_Point {
+		if fv.DateTimeValue == nil {
+			return nil, unexpected("dateTimeValue")
+		}
+
+		switch fd.GetDatetime().GetType() {
+		case api.CustomizedDatetimeConfig_Point:
 			return &fieldvalue.FieldValue{
 				NumberInt: fv.DateTimeValue.ConvertToTimePoint(),
-			}
-		}
-		if fd.GetDatetime().Type == api.CustomizedDatetimeConfig_Range {
+			}, nil
+		case api.CustomizedDatetimeConfig_Range:
 			return &fieldvalue.FieldValue{
 				NumberIntArray: fv.DateTimeValue.ConvertToTimeInterval(),
-			}
+			}, nil
 		}
 
-		return nil
+		return nil, fmt.Errorf("unknown datetime type %d", fd.GetDatetime().GetType())
 	case api.CustomizedFieldType_Enumeration:
+		if fv.EnumValue == nil {
+			return nil, unexpected("enumValue")
+		}
+
 		return convertEnumer(fd, fv)
 	case api.CustomizedFieldType_File:
+		if fv.FileValue == nil {
+			return nil, unexpected("fileValue")
+		}
+
 		return &fieldvalue.FieldValue{
 			File: fv.FileValue.convert(),
-		}
+		}, nil
 	case api.CustomizedFieldType_Image:
+		if fv.ImageValue == nil {
+			return nil, unexpected("imageValue")
+		}
+
 		return &fieldvalue.FieldValue{
 			Image: fv.ImageValue.convert(),
-		}
+		}, nil
 	case api.CustomizedFieldType_Video:
+		if fv.VideoValue == nil {
+			return nil, unexpected("videoValue")
+		}
+
 		return &fieldvalue.FieldValue{
 			Video: fv.VideoValue.convert(),
-		}
+		}, nil
 	case api.CustomizedFieldType_Color:
-		return &fieldvalue.FieldValue{
-			Text: fv.ColorValue.convert(),
+		// 早期颜色以文本保存
+		if fv.ColorValue != nil {
+			return &fieldvalue.FieldValue{Text: fv.ColorValue.convert()}, nil
 		}
+		if fv.TextValue != nil {
+			return &fieldvalue.FieldValue{Text: fv.TextValue.convert()}, nil
+		}
+
+		return nil, unexpected("colorValue")
 	case api.CustomizedFieldType_MaterialReference:
+		if fv.ReferenceValue == nil {
+			return nil, unexpected("referenceValue")
+		}
+
 		return &fieldvalue.FieldValue{
 			Reference: fv.ReferenceValue.convert(),
+		}, nil
+	case api.CustomizedFieldType_Goto:
+		return fv.convertGoto(unexpected)
+	case api.CustomizedFieldType_Tag:
+		// 标签统一转为文本数组, 兼容以枚举、文本保存的旧数据
+		switch {
+		case fv.TagValue != nil:
+			return &fieldvalue.FieldValue{TextArray: fv.TagValue.convert()}, nil
+		case fv.EnumValue != nil:
+			return &fieldvalue.FieldValue{TextArray: fv.EnumValue.ConvertToMutilString()}, nil
+		case fv.TextValue != nil:
+			return &fieldvalue.FieldValue{TextArray: splitText(fv.TextValue.Value)}, nil
 		}
-	default:
-		return nil
+
+		return nil, unexpected("tagValue")
+	}
+
+	return nil, fmt.Errorf("unsupported customized field type %d", fd.CustomizedFieldType)
+}
+
+// convertNumber 数字字段, 兼容旧数据中以 numberValue(float) 保存的值
+// 浮点数转为整数字段时必须为整数, 避免精度丢失
+func (fv *FieldValue) convertNumber(
+	tp api.CustomizedNumberConfig_Type, unexpected func(string) error,
+) (*fieldvalue.FieldValue, error) {
+	if tp == api.CustomizedNumberConfig_Unknown {
+		// 字段表未配置类型时按数据推断
+		switch {
+		case fv.NumberIntValue != nil:
+			tp = api.CustomizedNumberConfig_Int
+		case fv.NumberFloatValue != nil, fv.NumberValue != nil:
+			tp = api.CustomizedNumberConfig_Float
+		default:
+			return nil, unexpected("numberInt or numberFloat")
+		}
+	}
+
+	switch tp {
+	case api.CustomizedNumberConfig_Int:
+		switch {
+		case fv.NumberIntValue != nil:
+			return &fieldvalue.FieldValue{NumberInt: fv.NumberIntValue.convert()}, nil
+		case fv.NumberFloatValue != nil && fv.NumberFloatValue.Value != nil:
+			return intFromFloat(*fv.NumberFloatValue.Value)
+		case fv.NumberValue != nil:
+			return intFromFloat(fv.NumberValue.Value)
+		}
+
+		return nil, unexpected("numberInt")
+	case api.CustomizedNumberConfig_Float:
+		switch {
+		case fv.NumberFloatValue != nil:
+			return &fieldvalue.FieldValue{NumberFloat: fv.NumberFloatValue.convert()}, nil
+		case fv.NumberValue != nil:
+			return &fieldvalue.FieldValue{NumberFloat: fv.NumberValue.convert()}, nil
+		case fv.NumberIntValue != nil && fv.NumberIntValue.Value != nil:
+			f := float64(*fv.NumberIntValue.Value)
+
+			return &fieldvalue.FieldValue{NumberFloat: &f}, nil
+		}
+
+		return nil, unexpected("numberFloat")
+	}
+
+	return nil, fmt.Errorf("unknown number type %d", tp)
+}
+
+func intFromFloat(f float64) (*fieldvalue.FieldValue, error) {
+	i := int64(f)
+	if float64(i) != f {
+		return nil, fmt.Errorf("number %v is not an integer", f)
+	}
+
+	return &fieldvalue.FieldValue{NumberInt: &i}, nil
+}
+
+// gotoTypeMaterial goto 字段中引用素材的类型, 其余类型(text)为直接输入的文本
+const gotoTypeMaterial = "material"
+
+// convertGoto goto 字段, 旧数据以 referenceValue、textValue 或 nameAndValuesValue(name 为类型) 保存
+func (fv *FieldValue) convertGoto(unexpected func(string) error) (*fieldvalue.FieldValue, error) {
+	switch {
+	case fv.ReferenceValue != nil:
+		return &fieldvalue.FieldValue{Reference: fv.ReferenceValue.convert()}, nil
+	case fv.TextValue != nil:
+		return &fieldvalue.FieldValue{Text: fv.TextValue.convert()}, nil
+	case len(fv.NameAndValuesValue) > 0:
+		nv := fv.NameAndValuesValue[0]
+		if nv == nil || len(nv.Value) == 0 {
+			return nil, nil
+		}
+		if nv.Name == gotoTypeMaterial {
+			return &fieldvalue.FieldValue{Reference: &fieldvalue.Reference{
+				Value: nv.Value,
+				Type:  fieldvalue.ReferenceType_Material,
+			}}, nil
+		}
+		if len(nv.Value) > 1 {
+			return nil, fmt.Errorf("goto of type %q has %d values, want 1", nv.Name, len(nv.Value))
+		}
+
+		return &fieldvalue.FieldValue{Text: &nv.Value[0]}, nil
 	}
+
+	return nil, unexpected("referenceValue, textValue or nameAndValuesValue")
 }
 
-func convertEnumer(fd *api.Field, fv *FieldValue) *fieldvalue.FieldValue {
+// splitText 按逗号拆分文本, 忽略空项
+func splitText(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// convertEnumer 枚举值按字段配置的值类型转换, 无法解析的值返回转换后的零值及 *CoercionError
+func convertEnumer(fd *api.Field, fv *FieldValue) (*fieldvalue.FieldValue, error) {
 	e := fd.GetEnumeration()
-	et := e.ValueType
-	switch et {
+	res := &fieldvalue.FieldValue{}
+	var err error
+	switch e.ValueType {
 	case 1: // int
 		if e.IsMultiple {
-			return &fieldvalue.FieldValue{
-				NumberIntArray: fv.EnumValue.ConvertToMutilInt(),
-			}
-		}
-
-		return &fieldvalue.FieldValue{
-			NumberInt: fv.EnumValue.ConvertToSingleInt(),
+			res.NumberIntArray, err = fv.EnumValue.ConvertToMutilInt()
+		} else {
+			res.NumberInt, err = fv.EnumValue.ConvertToSingleInt()
 		}
 	case 2: // bool
-		return &fieldvalue.FieldValue{
-			Bool: fv.EnumValue.ConvertToBool(),
-		}
+		res.Bool, err = fv.EnumValue.ConvertToBool()
 	case 3: // 浮点
 		if e.IsMultiple {
-			return &fieldvalue.FieldValue{
-				NumberFloatArray: fv.EnumValue.ConvertToMutilFloat(),
-			}
-		}
-
-		return &fieldvalue.FieldValue{
-			NumberFloat: fv.EnumValue.ConvertToSingleFloat(),
+			res.NumberFloatArray, err = fv.EnumValue.ConvertToMutilFloat()
+		} else {
+			res.NumberFloat, err = fv.EnumValue.ConvertToSingleFloat()
 		}
 	default: // 字符串
 		if e.IsMultiple {
-			return &fieldvalue.FieldValue{
-				TextArray: fv.EnumValue.ConvertToMutilString(),
-			}
-		}
-
-		return &fieldvalue.FieldValue{
-			Text: fv.EnumValue.ConvertToSingleString(),
+			res.TextArray = fv.EnumValue.ConvertToMutilString()
+		} else {
+			res.Text = fv.EnumValue.ConvertToSingleString()
 		}
 	}
+
+	return res, err
 }
 
 type OldMaterial struct {
@@ -602,9 +832,10 @@ type OldMaterial struct {
 	UpdatedAt          time.Time            `bson:"updatedAt"`
 }
 
-func (om *OldMaterial) convert(fd *api.FieldsDefinition) *Material {
+// convert 转为新素材, 同时返回转换错误, 无法转换的字段不会写入, 被强制转换的字段保留零值
+func (om *OldMaterial) convert(fd *api.FieldsDefinition) (*Material, []error) {
 	if om == nil {
-		return nil
+		return nil, nil
 	}
 
 	m := &Material{
@@ -633,11 +864,12 @@ func (om *OldMaterial) convert(fd *api.FieldsDefinition) *Material {
 		Status:    om.Status,
 	}
 
-	mv.Custom = convertCustomAttribuite(fd, om.CustomAttributes)
-	mv.Localize = convertLocalizedAttribute(fd, om.LocalizeAttributes)
+	var errs, lerrs []error
+	mv.Custom, errs = convertCustomAttribuite(fd, om.CustomAttributes)
+	mv.Localize, lerrs = convertLocalizedAttribute(fd, om.LocalizeAttributes)
 	m.Versions = append(m.Versions, mv)
 
-	return m
+	return m, append(errs, lerrs...)
 }
 
 type OldCategory struct {
@@ -657,9 +889,10 @@ type OldCategory struct {
 	UpdatedAt          time.Time            `bson:"updatedAt"`
 }
 
-func (oc *OldCategory) convert(fd *api.FieldsDefinition) *Category {
+// convert 转为新分类, 同时返回转换错误, 无法转换的字段不会写入, 被强制转换的字段保留零值
+func (oc *OldCategory) convert(fd *api.FieldsDefinition) (*Category, []error) {
 	if oc == nil {
-		return nil
+		return nil, nil
 	}
 
 	res := &Category{
@@ -681,12 +914,13 @@ func (oc *OldCategory) convert(fd *api.FieldsDefinition) *Category {
 		UpdatedAt:   oc.UpdatedAt,
 	}
 
-	cv.Custom = convertCustomAttribuite(fd, oc.CustomAttributes)
-	cv.Localize = convertLocalizedAttribute(fd, oc.LocalizeAttributes)
+	var errs, lerrs []error
+	cv.Custom, errs = convertCustomAttribuite(fd, oc.CustomAttributes)
+	cv.Localize, lerrs = convertLocalizedAttribute(fd, oc.LocalizeAttributes)
 
 	res.Versions = append(res.Versions, cv)
 
-	return res
+	return res, append(errs, lerrs...)
 }
 
 type Category struct {
@@ -819,6 +1053,25 @@ func (mv *MaterialVersion) GetBase() map[string]*fieldvalue.FieldValue {
 	return res
 }
 
+func (cv *CategoryVersion) GetLocalize() []map[string]*fieldvalue.FieldValue {
+	res := make([]map[string]*fieldvalue.FieldValue, 0, len(cv.Localize))
+	for i := range cv.Localize {
+		res = append(res, cv.Localize[i].GetLocalize())
+	}
+
+	return res
+}
+
+func (cv *CategoryVersion) GetBase() map[string]*fieldvalue.FieldValue {
+	res := make(map[string]*fieldvalue.FieldValue)
+	res[pkg.Code_Name] = fieldvalue.TextFieldValue(cv.Name)
+	for k, v := range cv.Custom {
+		res[k] = v
+	}
+
+	return res
+}
+
 func getFieldByCode(code string, fd *api.FieldsDefinition) *api.Field {
 	if fd == nil {
 		return nil
@@ -833,31 +1086,43 @@ func getFieldByCode(code string, fd *api.FieldsDefinition) *api.Field {
 	return nil
 }
 
-func convertCustomAttribuite(fd *api.FieldsDefinition, attr []*CustomAttribute) map[string]*fieldvalue.FieldValue {
+func convertCustomAttribuite(
+	fd *api.FieldsDefinition, attr []*CustomAttribute,
+) (map[string]*fieldvalue.FieldValue, []error) {
 	res := make(map[string]*fieldvalue.FieldValue)
 	if fd == nil {
-		return res
+		return res, nil
 	}
 
+	var errs []error
 	for _, v := range attr {
 		if v == nil {
 			continue
 		}
 
 		field := getFieldByCode(v.Code, fd)
-		res[v.Code] = v.convert(field)
+		fv, err := v.convert(field)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if fv != nil || err == nil {
+			res[v.Code] = fv
+		}
 	}
 
-	return res
+	return res, errs
 }
 
-func convertLocalizedAttribute(fd *api.FieldsDefinition, locAttr []*LocalizeAttribute) []*fieldvalue.Localize {
+func convertLocalizedAttribute(fd *api.FieldsDefinition, locAttr []*LocalizeAttribute) ([]*fieldvalue.Localize, []error) {
 	res := make([]*fieldvalue.Localize, 0, len(locAttr))
+	var errs []error
 	for _, v := range locAttr {
-		res = append(res, v.convert(fd))
+		loc, lerrs := v.convert(fd)
+		res = append(res, loc)
+		errs = append(errs, lerrs...)
 	}
 
-	return res
+	return res, errs
 }
 
 func VersionFromSystemAttributes(sysAttr []*CustomAttribute) fieldvalue.Version {
@@ -1061,47 +1326,6 @@ type (
 	}
 )
 
-// SyncRecoder 同步数据的记录
-type SyncRecoder struct {
-	DBFullColl string
-	Type       int
-	ID         string
-	Scope      string
-	Env        string
-	Err        string
-}
-
-func NewSyncRecoder(dbfc, id, err, scope, env string, tp int) *SyncRecoder {
-	return &SyncRecoder{
-		DBFullColl: dbfc,
-		Type:       tp,
-		ID:         id,
-		Err:        err,
-		Scope:      scope,
-		Env:        env,
-	}
-}
-
-type FindOptions struct {
-	ldao.MongodbFindOptions
-}
-
-func (opt *FindOptions) Filter() (interface{}, error) {
-	return primitive.M{}, nil
-}
-
-func (opt *FindOptions) Sorts() ldao.SortFields {
-	// 去除lint警告而已,后续通过lint检测配置处理
-	_ = opt
-
-	return ldao.SortFields{
-		{
-			Name:      "_id",
-			Direction: ldao.SortFieldDesc,
-		},
-	}
-}
-
 type UnityFontFindOptions struct {
 	ldao.MongodbFindOptions
 }
@@ -1175,9 +1399,11 @@ type Plan struct {
 	*EditInfo             `bson:",inline"`
 }
 
-var cacheOverrideVersionID = make(map[string]string)
-
-func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string) (bool, error) {
+// newOverrideMaterialsVersion 为覆盖了 vip 或有效期的素材生成覆盖版本, ids 不允许的素材跳过
+// cache 为覆盖 key 到版本 ID 的缓存, 由调用方按执行单元创建
+func (p *Plan) newOverrideMaterialsVersion(
+	mdb dao.MongodbDAO, ids *idlist.Filter, cache map[string]string, scope, env string,
+) (bool, error) {
 	if p.PlacingContent == nil {
 		return false, nil
 	}
@@ -1188,7 +1414,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 				if c.Materials != nil {
 					for _, m := range c.Materials {
 						if m.hasOverride() {
-							if !isExcludeMaterialsID(scope, env, m.ID) {
+							if !ids.Allow(scope, env, m.ID) {
 								continue
 							}
 							key := m.getCacheKey()
@@ -1202,14 +1428,14 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 								if vid != "" {
 									m.VersionID = vid
 									hasOverride = true
-									cacheOverrideVersionID[key] = vid
+									cache[key] = vid
 								}
 								continue
 							}
 
 							// 需要生成新的素材版本支持覆盖数据
 							defv := material.Versions[0]
-							if versionID, ok := cacheOverrideVersionID[key]; ok {
+							if versionID, ok := cache[key]; ok {
 								ovid, err := primitive.ObjectIDFromHex(versionID)
 								if err != nil {
 									return false, err
@@ -1236,7 +1462,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 							defv.VersionName = fmt.Sprintf("覆盖版本%d", len(material.Versions))
 							material.addVersion(defv)
 							// TODO: 更新素材
-							if _, err := mdb.Collection().UpdateOne(
+							if _, err := store.Wrap(mdb.Collection()).UpdateOne(
 								context.Background(), primitive.M{"_id": material.ID}, op.Set(material),
 								options.Update().SetUpsert(true),
 							); err != nil {
@@ -1246,7 +1472,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 
 							m.VersionID = defv.VersionID.Hex()
 							hasOverride = true
-							cacheOverrideVersionID[key] = m.VersionID
+							cache[key] = m.VersionID
 						}
 					}
 				}
@@ -1256,7 +1482,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 		if v.Materials != nil {
 			for _, m := range v.Materials {
 				if m.hasOverride() {
-					if !isExcludeMaterialsID(scope, env, m.ID) {
+					if !ids.Allow(scope, env, m.ID) {
 						continue
 					}
 					key := m.getCacheKey()
@@ -1270,14 +1496,14 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 						if vid != "" {
 							m.VersionID = vid
 							hasOverride = true
-							cacheOverrideVersionID[key] = vid
+							cache[key] = vid
 						}
 						continue
 					}
 
 					// 需要生成新的素材版本支持覆盖数据
 					defv := material.Versions[0]
-					if versionID, ok := cacheOverrideVersionID[key]; ok {
+					if versionID, ok := cache[key]; ok {
 						ovid, err := primitive.ObjectIDFromHex(versionID)
 						if err != nil {
 							return false, err
@@ -1304,7 +1530,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 					defv.VersionName = fmt.Sprintf("覆盖版本%d", len(material.Versions))
 					material.addVersion(defv)
 					// TODO: 更新素材
-					if _, err := mdb.Collection().UpdateOne(
+					if _, err := store.Wrap(mdb.Collection()).UpdateOne(
 						context.Background(), primitive.M{"_id": material.ID}, op.Set(material),
 						options.Update().SetUpsert(true),
 					); err != nil {
@@ -1314,7 +1540,7 @@ func (p *Plan) newOverrideMaterialsVersion(mdb dao.MongodbDAO, scope, env string
 
 					m.VersionID = defv.VersionID.Hex()
 					hasOverride = true
-					cacheOverrideVersionID[key] = vid
+					cache[key] = vid
 				}
 			}
 		}
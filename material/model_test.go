@@ -0,0 +1,90 @@
+package material
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pinguo-icc/field-definitions/api"
+	"github.com/pinguo-icc/field-definitions/pkg/fieldvalue"
+)
+
+func TestFieldValueConvert(t *testing.T) {
+	intField := &api.Field{
+		CustomizedFieldType: api.CustomizedFieldType_Number,
+		Data:                &api.Field_Number{Number: &api.CustomizedNumberConfig{Type: api.CustomizedNumberConfig_Int}},
+	}
+	gotoField := &api.Field{CustomizedFieldType: api.CustomizedFieldType_Goto, Data: &api.Field_Goto{}}
+	tagField := &api.Field{CustomizedFieldType: api.CustomizedFieldType_Tag, Data: &api.Field_Tag{}}
+
+	for _, c := range []struct {
+		name    string
+		field   *api.Field
+		value   *FieldValue
+		wantErr bool
+		check   func(*testing.T, *fieldvalue.FieldValue)
+	}{
+		{name: "legacy number into int", field: intField, value: &FieldValue{NumberValue: &NumberValue{Value: 3}},
+			check: func(t *testing.T, fv *fieldvalue.FieldValue) {
+				if fv.NumberInt == nil || *fv.NumberInt != 3 {
+					t.Errorf("NumberInt = %v, want 3", fv.NumberInt)
+				}
+			}},
+		{name: "fraction into int", field: intField, value: &FieldValue{NumberValue: &NumberValue{Value: 3.5}}, wantErr: true},
+		{name: "text into int", field: intField, value: &FieldValue{TextValue: &TextValue{Value: "3"}}, wantErr: true},
+		{name: "goto text", field: gotoField, value: &FieldValue{TextValue: &TextValue{Value: "pg://home"}},
+			check: func(t *testing.T, fv *fieldvalue.FieldValue) {
+				if fv.GetText() != "pg://home" {
+					t.Errorf("Text = %q", fv.GetText())
+				}
+			}},
+		{name: "goto material", field: gotoField, value: &FieldValue{
+			NameAndValuesValue: NameAndValuesValues{{Name: "material", Value: []string{"a", "b"}}},
+		}, check: func(t *testing.T, fv *fieldvalue.FieldValue) {
+			if fv.Reference == nil || len(fv.Reference.Value) != 2 {
+				t.Errorf("Reference = %+v, want 2 materials", fv.Reference)
+			}
+		}},
+		{name: "tag from text", field: tagField, value: &FieldValue{TextValue: &TextValue{Value: "a, b,"}},
+			check: func(t *testing.T, fv *fieldvalue.FieldValue) {
+				if len(fv.TextArray) != 2 || fv.TextArray[1] != "b" {
+					t.Errorf("TextArray = %v, want [a b]", fv.TextArray)
+				}
+			}},
+		{name: "empty value", field: tagField, value: &FieldValue{},
+			check: func(t *testing.T, fv *fieldvalue.FieldValue) {
+				if fv != nil {
+					t.Errorf("fv = %+v, want nil", fv)
+				}
+			}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			fv, err := c.value.convert(c.field)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, c.wantErr)
+			}
+			if err == nil && c.check != nil {
+				c.check(t, fv)
+			}
+		})
+	}
+}
+
+func TestCustomAttributeCoercion(t *testing.T) {
+	field := &api.Field{
+		CustomizedFieldType: api.CustomizedFieldType_Enumeration,
+		Data: &api.Field_Enumeration{Enumeration: &api.CustomizedEnumerationConfig{
+			IsMultiple: true,
+			ValueType:  1,
+		}},
+	}
+	ca := &CustomAttribute{Code: "level", Value: &FieldValue{EnumValue: &EnumValue{Value: []string{"1", "high"}}}}
+
+	fv, err := ca.convert(field)
+	var ce *ConvertError
+	if !errors.As(err, &ce) || !ce.Coerced || ce.Code != "level" {
+		t.Fatalf("err = %v, want a coerced ConvertError of level", err)
+	}
+	if fv == nil || len(fv.NumberIntArray) != 2 || fv.NumberIntArray[1] != 0 {
+		t.Errorf("fv = %+v, want the coerced values kept", fv)
+	}
+}
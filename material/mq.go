@@ -5,57 +5,62 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/pinguo-icc/go-base/v2/event"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/store"
 	h5api "github.com/pinguo-icc/operational-h5-svc/api"
 	mapi "github.com/pinguo-icc/operational-materials-svc/api"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
-var (
-	// dev
-	// kafkaAddrs = []string{
-	// 	"a4c75dcbd52214b55bd344496bdb5901-f4ea52a09ad4dc1d.elb.cn-northwest-1.amazonaws.com.cn:32000",
-	// 	"ad435efd7df774eceac057d854557280-e47fe7160bccdf15.elb.cn-northwest-1.amazonaws.com.cn:32000",
-	// 	"a39796aa1f0324c6d9ca4d32e4ced8dd-67c2f7f060c9452a.elb.cn-northwest-1.amazonaws.com.cn:32000",
-	// }
+// InitMQ 创建事件发送方式: 指定了 --events-file 时写入文件, 否则按 --mq-profile 连接 kafka,
+// 发送失败的事件写入死信;
+// dry-run 且未指定文件时不发送, 返回nil
+func InitMQ(opt *Options) (events.Publisher, func(), error) {
+	if opt.EventsFile != "" {
+		f, err := events.OpenFile(opt.EventsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return f, func() { _ = f.Close() }, nil
+	}
 
-	// QA
-	// kafkaAddrs = []string{
-	// 	"47.97.215.66:32000",
-	// 	"118.31.75.196:32000",
-	// 	"118.31.42.178:32000",
-	// }
+	// dry-run 模式下事件只做记录, 不需要连接 kafka
+	if store.DryRun() {
+		return nil, func() {}, nil
+	}
 
-	// prod
-	kafkaAddrs = []string{
-		"alikafka-pre-cn-uqm32h3zm00b-1.alikafka.aliyuncs.com:9093",
-		"alikafka-pre-cn-uqm32h3zm00b-2.alikafka.aliyuncs.com:9093",
-		"alikafka-pre-cn-uqm32h3zm00b-3.alikafka.aliyuncs.com:9093",
+	k, cancel, err := events.NewKafka(opt.MQ)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opt.DeadLetters != nil {
+		return opt.DeadLetters.Wrap(k), cancel, nil
+	}
+
+	return k, cancel, nil
+}
+
+// send 发送事件, dry-run 模式下只记录将要发送的事件
+func send(ctx context.Context, mq events.Publisher, scope, env, topic string, msg []*events.Envelope) error {
+	if store.DryRun() {
+		store.RecordEvents(scope, env, topic, len(msg))
+		// 指定了 --events-file 时 dry-run 也写入文件, 便于审阅
+		if mq == nil {
+			return nil
+		}
 	}
-)
 
-func InitMQ() (event.Sender, func()) {
-	return event.NewKafkaSender(&event.Config{
-		Base: event.BaseConfig{
-			UserName: "cnhzkafka",
-			Password: "CamerA3602023",
-		},
-		Write: event.WriteConfig{
-			Addr:            kafkaAddrs,
-			BatchSize:       1,
-			WriteBackoffMin: 1 * time.Millisecond,
-		},
-		Read: event.ReaderConfig{},
-	})
+	return mq.Publish(ctx, msg...)
 }
 
-func sendMaterialCreateMessage(ctx context.Context, mq event.Sender, scope, env string, datas []*Material) error {
+func sendMaterialCreateMessage(ctx context.Context, mq events.Publisher, scope, env string, datas []*Material) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-materials-svc", "material")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, v := range datas {
 		traceID := v.ID.Hex() + "sync_by_cli"
 		fmt.Println("traceID", traceID)
@@ -70,25 +75,25 @@ func sendMaterialCreateMessage(ctx context.Context, mq event.Sender, scope, env
 			return err
 		}
 
-		msg = append(msg, event.NewEvent(
-			data,
-			v.ID.Hex(),
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     v.ID.Hex(),
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
 
-func sendCategoryCreateMessage(ctx context.Context, mq event.Sender, scope, env string, datas []*Category) error {
+func sendCategoryCreateMessage(ctx context.Context, mq events.Publisher, scope, env string, datas []*Category) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-materials-svc", "category")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, v := range datas {
 		traceID := v.ID.Hex() + "sync_by_cli"
 		fmt.Println("traceID", traceID)
@@ -102,25 +107,25 @@ func sendCategoryCreateMessage(ctx context.Context, mq event.Sender, scope, env
 			return err
 		}
 
-		msg = append(msg, event.NewEvent(
-			data,
-			v.ID.Hex(),
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     v.ID.Hex(),
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
 
-func sendCategoryUpdateMessage(ctx context.Context, mq event.Sender, scope, env string, datas [][]*Category) error {
+func sendCategoryUpdateMessage(ctx context.Context, mq events.Publisher, scope, env string, datas [][]*Category) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-materials-svc", "category")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, cates := range datas {
 		oe := &mapi.CategoryOperateEvent{
 			Data:        []*mapi.Category{},
@@ -139,25 +144,25 @@ func sendCategoryUpdateMessage(ctx context.Context, mq event.Sender, scope, env
 		traceID := xx.ID.Hex() + "sync_by_cli"
 		fmt.Println("traceID", traceID)
 
-		msg = append(msg, event.NewEvent(
-			data,
-			xx.ID.Hex(),
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     xx.ID.Hex(),
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
 
-func sendMaterialPositionCreateMessage(ctx context.Context, mq event.Sender, scope, env string, datas []*MaterialPosition) error {
+func sendMaterialPositionCreateMessage(ctx context.Context, mq events.Publisher, scope, env string, datas []*MaterialPosition) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-materials-svc", "position")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, v := range datas {
 		traceID := v.ID.Hex() + "sync_by_cli"
 		fmt.Println("traceID", traceID)
@@ -172,25 +177,25 @@ func sendMaterialPositionCreateMessage(ctx context.Context, mq event.Sender, sco
 			return err
 		}
 
-		msg = append(msg, event.NewEvent(
-			data,
-			v.ID.Hex(),
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     v.ID.Hex(),
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
 
-func sendMaterialPlanCreateMessage(ctx context.Context, mq event.Sender, scope, env string, datas []*Plan) error {
+func sendMaterialPlanCreateMessage(ctx context.Context, mq events.Publisher, scope, env string, datas []*Plan) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-materials-svc", "plan")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, v := range datas {
 		traceID := v.ID.Hex() + "sync_by_cli"
 		fmt.Println("traceID", traceID)
@@ -205,25 +210,25 @@ func sendMaterialPlanCreateMessage(ctx context.Context, mq event.Sender, scope,
 			return err
 		}
 
-		msg = append(msg, event.NewEvent(
-			data,
-			v.ID.Hex(),
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     v.ID.Hex(),
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
 
-func SendOperitionPositionCreateMesssage(ctx context.Context, mq event.Sender, scope, env string, datas []*H5PropertiesWithActName) error {
+func SendOperitionPositionCreateMesssage(ctx context.Context, mq events.Publisher, scope, env string, datas []*H5PropertiesWithActName) error {
 	topic := fmt.Sprintf("%s.%s.operate", "operational-h5-svc", "properties")
 	headers := map[string]string{
 		"scope": scope,
 		"env":   env,
 	}
-	msg := []event.Event{}
+	msg := []*events.Envelope{}
 	for _, v := range datas {
 		traceID := v.ID + "sync_by_cli"
 		fmt.Println("traceID", traceID)
@@ -247,14 +252,14 @@ func SendOperitionPositionCreateMesssage(ctx context.Context, mq event.Sender, s
 			return err
 		}
 
-		msg = append(msg, event.NewEvent(
-			data,
-			v.ID,
-			event.WithHeaders(headers),
-			event.WithTopic(topic),
-			event.WithHeaderTrackID(traceID),
-		))
+		msg = append(msg, &events.Envelope{
+			Topic:   topic,
+			Key:     v.ID,
+			Headers: headers,
+			TrackID: traceID,
+			Payload: data,
+		})
 	}
 
-	return mq.Send(ctx, msg...)
+	return send(ctx, mq, scope, env, topic, msg)
 }
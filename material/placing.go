@@ -7,164 +7,203 @@ import (
 	"log"
 	"strings"
 
-	"github.com/pinguo-icc/go-base/v2/event"
 	"github.com/pinguo-icc/go-lib/v2/dao"
 	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/checkpoint"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/idlist"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func SyncMaterialsPosition(ctx context.Context, client *mongo.Client) error {
-	mq, cancel := InitMQ()
+func SyncMaterialsPosition(ctx context.Context, client *mongo.Client, opt *Options) error {
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
 	defer cancel()
-	defer func() {
-		if len(materialPositionSyncErr) > 0 {
-			_ = wirteCvs("sync_material_position", materialPositionSyncErr)
-		}
-	}()
-	for old, new := range dbOldPosNewMap {
-		log.Printf("========= sync material_position %s to %s start===========\n", old, new)
-		oldMDB := dao.NewMongodbDAO(client.Database(old), "materialPosition")
-		newMDB := dao.NewMongodbDAO(client.Database(new), "materialPosition")
-
-		sps := strings.Split(old, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
 
-		scope, env := sps[0], sps[1]
+	spaces := spaceDBs(opt.Matrix)
+	units := make([]executor.Unit, 0, len(spaces))
+	for _, s := range spaces {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				old, new := s.oldPosition, s.newMaterial
+				log.Printf("========= sync material_position %s to %s start===========\n", old, new)
+				oldMDB := dao.NewMongodbDAO(client.Database(old), "materialPosition")
+				newMDB := dao.NewMongodbDAO(client.Database(new), "materialPosition")
+
+				err := doSyncMaterialPosition(
+					ctx, oldMDB, newMDB, mq, opt.Checkpoints, opt.Report, opt.IDs, opt.Batch, st, s.Scope, s.Env,
+				)
+				if err != nil {
+					return fmt.Errorf("sync %s to %s: %w", old, new, err)
+				}
 
-		if err := doSyncMaterialPosition(ctx, oldMDB, newMDB, mq, scope, env); err != nil {
-			log.Printf("sync style %s to %s error :%v", old, new, err)
-		}
+				log.Printf("========== run sync %s to %s end \n", old, new)
 
-		log.Printf("========== run sync %s to %s end \n", old, new)
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func doSyncMaterialPosition(_ context.Context, oldMDB, newMDB dao.MongodbDAO, mq event.Sender, scope, env string) error {
+func doSyncMaterialPosition(
+	_ context.Context, oldMDB, newMDB dao.MongodbDAO,
+	mq events.Publisher, cp *checkpoint.Store, rep *report.Report, ids *idlist.Filter, batch SyncBatch,
+	st *executor.Stats, scope, env string,
+) error {
 	ctx := context.Background()
-	page := int32(1)
-	hasNext := true
-	for hasNext {
-		res, hn, err := getSyncDatats[MaterialPosition](ctx, oldMDB, page)
+	after, done, err := cp.Start(ctx, oldMDB.Collection())
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("%s.%s has been synced, skip", oldMDB.Collection().Database().Name(), oldMDB.Collection().Name())
+
+		return nil
+	}
+
+	// page 仅用于错误记录
+	for page := 1; ; page++ {
+		res, last, err := getSyncDatats[MaterialPosition](ctx, oldMDB, after, batch.size())
 		if err != nil {
 			return err
 		}
+		if len(res) == 0 {
+			break
+		}
 
-		mes := make([]*MaterialPosition, 0, len(res))
+		allowed := make([]*MaterialPosition, 0, len(res))
 		for _, v := range res {
-			if _, err := newMDB.Collection().UpdateOne(
-				ctx, primitive.M{"_id": v.ID}, op.Set(v),
-				options.Update().SetUpsert(true),
-			); err != nil {
-				materialPositionSyncErr = append(materialPositionSyncErr, NewSyncRecoder(
-					oldMDB.Collection().Name(),
-					v.ID.Hex(),
-					err.Error(),
-					scope,
-					env,
-					int(page),
-				))
-
-				continue
+			if ids.Allow(scope, env, v.ID.Hex()) {
+				allowed = append(allowed, v)
 			}
-
-			mes = append(mes, v)
 		}
 
+		mes, serr := upsertPage(ctx, newMDB.Collection(), oldMDB.Collection(), allowed, func(v *MaterialPosition) primitive.ObjectID {
+			return v.ID
+		}, batch, rep, report.Record{Kind: report.KindMaterialPosition, Page: page, Scope: scope, Env: env}, st)
+
 		if len(mes) > 0 {
-			if err := sendMaterialPositionCreateMessage(ctx, mq, scope, env, res); err != nil {
+			if err := sendMaterialPositionCreateMessage(ctx, mq, scope, env, mes); err != nil {
 				fmt.Printf("send material msg fail, err: %s", err.Error())
 			}
 		}
+		if serr != nil {
+			return fmt.Errorf("page %d: %w", page, serr)
+		}
 
-		page++
-		hasNext = hn
+		after = last
+		if err := cp.Save(ctx, oldMDB.Collection(), after); err != nil {
+			log.Printf("save checkpoint of %s error: %s", oldMDB.Collection().Name(), err)
+		}
 	}
 
-	return nil
+	return cp.Done(ctx, oldMDB.Collection())
 }
 
-func SyncMaterialsPlan(ctx context.Context, client *mongo.Client) error {
-	mq, cancel := InitMQ()
+func SyncMaterialsPlan(ctx context.Context, client *mongo.Client, opt *Options) error {
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
 	defer cancel()
-	defer func() {
-		if len(planPositionSyncErr) > 0 {
-			_ = wirteCvs("sync_material_plan", planPositionSyncErr)
-		}
-	}()
-	for old, new := range dbOldPosNewMap {
-		log.Printf("========= sync material_position %s to %s start===========\n", old, new)
-		oldMDB := dao.NewMongodbDAO(client.Database(old), "plan")
-		newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
-
-		sps := strings.Split(old, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
 
-		scope, env := sps[0], sps[1]
+	spaces := spaceDBs(opt.Matrix)
+	units := make([]executor.Unit, 0, len(spaces))
+	for _, s := range spaces {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				old, new := s.oldPosition, s.newMaterial
+				log.Printf("========= sync material_position %s to %s start===========\n", old, new)
+				oldMDB := dao.NewMongodbDAO(client.Database(old), "plan")
+				newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
+
+				err := doSyncMaterialPlan(
+					ctx, oldMDB, newMDB, mq, opt.Checkpoints, opt.Report, opt.IDs, opt.Batch, st, s.Scope, s.Env,
+				)
+				if err != nil {
+					return fmt.Errorf("sync %s to %s: %w", old, new, err)
+				}
 
-		if err := doSyncMaterialPlan(ctx, oldMDB, newMDB, mq, scope, env); err != nil {
-			log.Printf("sync style %s to %s error :%v", old, new, err)
-		}
+				log.Printf("========== run sync %s to %s end \n", old, new)
 
-		log.Printf("========== run sync %s to %s end \n", old, new)
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-func doSyncMaterialPlan(_ context.Context, oldMDB, newMDB dao.MongodbDAO, mq event.Sender, scope, env string) error {
+func doSyncMaterialPlan(
+	_ context.Context, oldMDB, newMDB dao.MongodbDAO,
+	mq events.Publisher, cp *checkpoint.Store, rep *report.Report, ids *idlist.Filter, batch SyncBatch,
+	st *executor.Stats, scope, env string,
+) error {
 	ctx := context.Background()
-	page := int32(1)
-	hasNext := true
-	for hasNext {
-		res, hn, err := getSyncDatats[Plan](ctx, oldMDB, page)
+	after, done, err := cp.Start(ctx, oldMDB.Collection())
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("%s.%s has been synced, skip", oldMDB.Collection().Database().Name(), oldMDB.Collection().Name())
+
+		return nil
+	}
+
+	// page 仅用于错误记录
+	for page := 1; ; page++ {
+		res, last, err := getSyncDatats[Plan](ctx, oldMDB, after, batch.size())
 		if err != nil {
 			return err
 		}
+		if len(res) == 0 {
+			break
+		}
 
-		mes := make([]*Plan, 0, len(res))
+		allowed := make([]*Plan, 0, len(res))
 		for _, v := range res {
-			if _, err := newMDB.Collection().UpdateOne(
-				ctx, primitive.M{"_id": v.ID}, op.Set(v),
-				options.Update().SetUpsert(true),
-			); err != nil {
-				planPositionSyncErr = append(planPositionSyncErr, NewSyncRecoder(
-					oldMDB.Collection().Name(),
-					v.ID.Hex(),
-					err.Error(),
-					scope,
-					env,
-					int(page),
-				))
-
-				continue
+			if ids.Allow(scope, env, v.ID.Hex()) {
+				allowed = append(allowed, v)
 			}
-
-			mes = append(mes, v)
 		}
 
+		mes, serr := upsertPage(ctx, newMDB.Collection(), oldMDB.Collection(), allowed, func(v *Plan) primitive.ObjectID {
+			return v.ID
+		}, batch, rep, report.Record{Kind: report.KindPlan, Page: page, Scope: scope, Env: env}, st)
+
 		if len(mes) > 0 {
-			if err := sendMaterialPlanCreateMessage(ctx, mq, scope, env, res); err != nil {
+			if err := sendMaterialPlanCreateMessage(ctx, mq, scope, env, mes); err != nil {
 				fmt.Printf("send material msg fail, err: %s", err.Error())
 			}
 		}
-		page++
-		hasNext = hn
+		if serr != nil {
+			return fmt.Errorf("page %d: %w", page, serr)
+		}
+
+		after = last
+		if err := cp.Save(ctx, oldMDB.Collection(), after); err != nil {
+			log.Printf("save checkpoint of %s error: %s", oldMDB.Collection().Name(), err)
+		}
 	}
 
-	return nil
+	return cp.Done(ctx, oldMDB.Collection())
 }
 
-func DealPlan(_ context.Context, client *mongo.Client) error {
-	inner := func(db dao.MongodbDAO, scope, env string) {
-		ctx := context.Background()
+func DealPlan(ctx context.Context, client *mongo.Client, opt *Options) error {
+	inner := func(ctx context.Context, db dao.MongodbDAO, scope, env string) error {
 		cond := primitive.D{
 			{"$or",
 				primitive.A{
@@ -214,100 +253,113 @@ func DealPlan(_ context.Context, client *mongo.Client) error {
 
 		res, err := db.Collection().CountDocuments(ctx, cond)
 		if err != nil {
-			fmt.Println(err)
+			return err
 		}
 
 		if res > 0 {
 			msg := fmt.Sprintf("%s 有%d条投放覆盖数据%s-%s", db.Collection().Name(), res, scope, env)
 			fmt.Println(msg)
 		}
+
+		return nil
 	}
 
-	for old, new := range dbOldPosNewMap {
-		log.Printf("========= count material plan overrade %s ===========\n", new)
-		newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
+	units := []executor.Unit{}
+	for _, s := range spaceDBs(opt.Matrix) {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				new := s.newMaterial
+				log.Printf("========= count material plan overrade %s ===========\n", new)
+				newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
+				if err := inner(ctx, newMDB, s.Scope, s.Env); err != nil {
+					return fmt.Errorf("count plan override %s: %w", new, err)
+				}
+
+				log.Printf("========== count material plan overrade %s  \n", new)
 
-		sps := strings.Split(old, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
+				return nil
+			},
+		})
+	}
 
-		scope, env := sps[0], sps[1]
+	return opt.Run(ctx, units)
+}
 
-		inner(newMDB, scope, env)
+// 检查是否有覆盖数据
+func DealWithPlanBytraverse(ctx context.Context, client *mongo.Client, opt *Options) error {
+	units := []executor.Unit{}
+	for _, s := range spaceDBs(opt.Matrix) {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(_ context.Context, st *executor.Stats) error {
+				new := s.newMaterial
+				log.Printf("========= count material plan overrade %s ===========\n", new)
+				newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
+				newDBMaterils := dao.NewMongodbDAO(client.Database(new), "material")
+				if err := dealPlanOverride(newMDB, newDBMaterils, opt.IDs, st, s.Scope, s.Env); err != nil {
+					return fmt.Errorf("plan override %s: %w", new, err)
+				}
 
-		log.Printf("========== count material plan overrade %s  \n", new)
+				log.Printf("========== count material plan overrade %s  \n", new)
+
+				return nil
+			},
+		})
 	}
 
-	return nil
+	return opt.Run(ctx, units)
 }
 
-// 检查是否有覆盖数据
-func DealWithPlanBytraverse(_ context.Context, client *mongo.Client) error {
-	inner := func(db, mdb dao.MongodbDAO, scope, env string) error {
-		page := int32(1)
-		hasNext := true
-		count := 0
-		ids := make([]string, 0)
-		for hasNext {
-			res, hn, err := getSyncDatats[Plan](context.Background(), db, page)
+// dealPlanOverride 为一个库中覆盖了 vip 或有效期的计划生成素材覆盖版本并更新计划
+func dealPlanOverride(db, mdb dao.MongodbDAO, ids *idlist.Filter, st *executor.Stats, scope, env string) error {
+	var after interface{}
+	cache := make(map[string]string)
+	updated := make([]string, 0)
+	for {
+		res, last, err := getSyncDatats[Plan](context.Background(), db, after, syncPageSize)
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 {
+			break
+		}
+
+		for _, v := range res {
+			hasOverride, err := v.newOverrideMaterialsVersion(mdb, ids, cache, scope, env)
 			if err != nil {
 				return err
 			}
-
-			for _, v := range res {
-				hasOverride, err := v.newOverrideMaterialsVersion(mdb, scope, env)
-				if err != nil {
+			if hasOverride {
+				if _, err := store.Wrap(db.Collection()).UpdateOne(
+					context.Background(), primitive.M{"_id": v.ID}, op.Set(v),
+					options.Update().SetUpsert(true),
+				); err != nil {
 					return err
 				}
-				if hasOverride {
-					if _, err := db.Collection().UpdateOne(
-						context.Background(), primitive.M{"_id": v.ID}, op.Set(v),
-						options.Update().SetUpsert(true),
-					); err != nil {
-						return err
-					}
-					fmt.Printf("%s计划已更新", v.ID.Hex())
-					count++
-					ids = append(ids, v.ID.Hex())
-					bt, err := json.Marshal(v)
-					if err == nil {
-						fmt.Println(string(bt))
-					}
+				fmt.Printf("%s计划已更新", v.ID.Hex())
+				st.Processed++
+				updated = append(updated, v.ID.Hex())
+				bt, err := json.Marshal(v)
+				if err == nil {
+					fmt.Println(string(bt))
 				}
 			}
-			page++
-			hasNext = hn
-		}
-
-		bt, err := json.Marshal(cacheOverrideVersionID)
-		if err == nil {
-			fmt.Println(string(bt))
 		}
 
-		if count > 0 {
-			msg := fmt.Sprintf("%s 有%d条投放覆盖数据%s-%s: ids:%s", db.Collection().Name(), count, scope, env, strings.Join(ids, ","))
-			fmt.Println(msg)
-		}
-
-		return nil
+		after = last
 	}
-	for old, new := range dbOldPosNewMap {
-		log.Printf("========= count material plan overrade %s ===========\n", new)
-		newMDB := dao.NewMongodbDAO(client.Database(new), "plan")
-		newDBMaterils := dao.NewMongodbDAO(client.Database(new), "material")
-		sps := strings.Split(old, "_")
-		if len(sps) < 2 {
-			return fmt.Errorf("cant get scope and env")
-		}
-
-		scope, env := sps[0], sps[1]
 
-		if err := inner(newMDB, newDBMaterils, scope, env); err != nil {
-			fmt.Println(err)
-		}
+	bt, err := json.Marshal(cache)
+	if err == nil {
+		fmt.Println(string(bt))
+	}
 
-		log.Printf("========== count material plan overrade %s  \n", new)
+	if st.Processed > 0 {
+		msg := fmt.Sprintf("%s 有%d条投放覆盖数据%s-%s: ids:%s", db.Collection().Name(), st.Processed, scope, env, strings.Join(updated, ","))
+		fmt.Println(msg)
 	}
 
 	return nil
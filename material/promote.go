@@ -0,0 +1,361 @@
+package material
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/diff"
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PromoteOptions 从源环境复制文档到其它环境的参数
+type PromoteOptions struct {
+	Kind string
+	// SourceEnv 源环境, 通常为 operation, 目标环境为 Options.Matrix 中的其它环境
+	SourceEnv string
+	// IDs 要复制的文档 _id, 与 Filter 同时指定时取交集
+	IDs []string
+	// Filter 源文档的查询条件
+	Filter bson.M
+}
+
+// promoteKind 可复制的数据, 库名为 <scope>_<env>_<db>
+type promoteKind struct {
+	db, coll string
+	// key 目标环境中匹配文档的字段, 与 _id 不同时会先删除目标环境中 _id 不一致的旧文档
+	key string
+	// send 发送对应的事件
+	send func(ctx context.Context, mq events.Publisher, client *mongo.Client, sp config.Space, pd *promoted) error
+}
+
+// promoted 写入目标环境的文档, created 为新增的文档, updated 为更新的文档, replaced[i] 为 updated[i] 覆盖的原文档
+type promoted struct {
+	created, updated, replaced []bson.Raw
+}
+
+var promoteKinds = map[string]promoteKind{
+	"material": {db: "operational_materials", coll: "material", key: "_id", send: sendPromotedMaterials},
+	"category": {db: "operational_materials", coll: "material_category", key: "_id", send: sendPromotedCategories},
+	"plan":     {db: "operational_materials", coll: "plan", key: "_id", send: sendPromotedPlans},
+	"position": {db: "operational_materials", coll: "materialPosition", key: "_id", send: sendPromotedPositions},
+	// h5 样式各空间以 activityID 关联, 复制后 _id 与源环境一致
+	"h5props": {db: "h5", coll: "properties", key: "activityID", send: sendPromotedH5Properties},
+}
+
+// PromoteKinds 返回支持的数据类型
+func PromoteKinds() []string {
+	res := make([]string, 0, len(promoteKinds))
+	for k := range promoteKinds {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// PromoteDB 返回 kind 写入的库(不含 <scope>_<env>_ 前缀), 不支持的 kind 返回空
+func PromoteDB(kind string) string {
+	return promoteKinds[kind].db
+}
+
+// Promote 将源环境中选定的文档原样(含 _id 及各版本ID)覆盖写入其它环境, 并按目标环境发送事件
+// 内容相同的文档不写入, 写入的文档通过 store 记入台账, 可配合 --backup 回滚
+func Promote(ctx context.Context, client *mongo.Client, opt *Options, p *PromoteOptions) error {
+	k, ok := promoteKinds[p.Kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q, use one of %s", p.Kind, strings.Join(PromoteKinds(), ", "))
+	}
+	if len(p.IDs) == 0 && p.Filter == nil {
+		return errors.New("please select the documents to promote with ids or a filter")
+	}
+
+	targets := opt.Matrix.Without(p.SourceEnv)
+	if len(targets.Spaces()) == 0 {
+		return fmt.Errorf("no target env other than %s, please set --env", p.SourceEnv)
+	}
+
+	filter := p.filter()
+
+	mq, cancel, err := InitMQ(opt)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 每个 scope 的源文档只读取一次
+	sources := map[string][]bson.Raw{}
+	for sp := range targets {
+		src := config.Space{Scope: sp, Env: p.SourceEnv}
+		docs, err := findRaw(ctx, client.Database(src.DBName(k.db)).Collection(k.coll), filter)
+		if err != nil {
+			return fmt.Errorf("load %s from %s: %w", p.Kind, src, err)
+		}
+		if len(docs) < len(p.IDs) {
+			log.Printf("%s: only %d of %d ids found in %s", p.Kind, len(docs), len(p.IDs), src)
+		}
+		sources[sp] = docs
+	}
+
+	units := []executor.Unit{}
+	for _, s := range targets.Spaces() {
+		s := s
+		units = append(units, executor.Unit{
+			Name: s.String(),
+			Run: func(ctx context.Context, st *executor.Stats) error {
+				coll := client.Database(s.DBName(k.db)).Collection(k.coll)
+				pd, err := promoteDocs(ctx, coll, k.key, sources[s.Scope], st)
+				if err != nil {
+					return err
+				}
+				log.Printf("promote %s to %s.%s: %d created, %d updated", p.Kind, s.DBName(k.db), k.coll, len(pd.created), len(pd.updated))
+
+				if len(pd.created)+len(pd.updated) == 0 {
+					return nil
+				}
+
+				return k.send(ctx, mq, client, s, pd)
+			},
+		})
+	}
+
+	return opt.Run(ctx, units)
+}
+
+// filter 合并 IDs 与 Filter
+func (p *PromoteOptions) filter() bson.M {
+	conds := bson.A{}
+	if p.Filter != nil {
+		conds = append(conds, p.Filter)
+	}
+
+	if len(p.IDs) > 0 {
+		ids := make(bson.A, 0, len(p.IDs))
+		for _, id := range p.IDs {
+			// h5 等集合的 _id 也是 ObjectID, 无法解析时按字符串匹配
+			if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+				ids = append(ids, oid)
+			} else {
+				ids = append(ids, id)
+			}
+		}
+		conds = append(conds, bson.M{"_id": bson.M{"$in": ids}})
+	}
+
+	if len(conds) == 1 {
+		return conds[0].(bson.M)
+	}
+
+	return bson.M{"$and": conds}
+}
+
+func findRaw(ctx context.Context, coll *mongo.Collection, filter interface{}) ([]bson.Raw, error) {
+	cur, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	res := []bson.Raw{}
+	for cur.Next(ctx) {
+		doc := make(bson.Raw, len(cur.Current))
+		copy(doc, cur.Current)
+		res = append(res, doc)
+	}
+
+	return res, cur.Err()
+}
+
+// promoteDocs 按 key 匹配目标文档并整体替换, 内容相同时跳过
+func promoteDocs(
+	ctx context.Context, coll *mongo.Collection, key string, docs []bson.Raw, st *executor.Stats,
+) (*promoted, error) {
+	pd := &promoted{}
+	for _, doc := range docs {
+		id := doc.Lookup("_id")
+		old, err := coll.FindOne(ctx, bson.M{key: doc.Lookup(key)}).DecodeBytes()
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return pd, err
+		}
+
+		if old != nil {
+			fields := diff.Docs(old, doc, nil)
+			if len(fields) == 0 {
+				continue
+			}
+
+			paths := make([]string, 0, len(fields))
+			for _, f := range fields {
+				paths = append(paths, f.Path)
+			}
+			log.Printf("promote %s.%s %s changes %s", coll.Database().Name(), coll.Name(), id, strings.Join(paths, ", "))
+
+			// 按 key 匹配到的旧文档 _id 不同, 先删除, 保证各环境 _id 一致
+			if oldID := old.Lookup("_id"); !oldID.Equal(id) {
+				if _, err := store.Wrap(coll).DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+					st.Failed++
+					log.Printf("delete %s.%s %s error: %s", coll.Database().Name(), coll.Name(), oldID, err)
+
+					continue
+				}
+			}
+		}
+
+		if _, err := store.Wrap(coll).ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true)); err != nil {
+			st.Failed++
+			log.Printf("promote %s.%s %s error: %s", coll.Database().Name(), coll.Name(), id, err)
+
+			continue
+		}
+
+		st.Processed++
+		if old == nil {
+			pd.created = append(pd.created, doc)
+		} else {
+			pd.updated = append(pd.updated, doc)
+			pd.replaced = append(pd.replaced, old)
+		}
+	}
+
+	return pd, nil
+}
+
+// all 新增及更新的文档
+func (pd *promoted) all() []bson.Raw {
+	return append(append([]bson.Raw{}, pd.created...), pd.updated...)
+}
+
+func decodeRaw[T any](docs []bson.Raw) ([]*T, error) {
+	res := make([]*T, 0, len(docs))
+	for _, doc := range docs {
+		t := new(T)
+		if err := bson.Unmarshal(doc, t); err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+
+	return res, nil
+}
+
+// sendPromotedMaterials 素材只有创建事件, 消费方按ID覆盖
+func sendPromotedMaterials(
+	ctx context.Context, mq events.Publisher, _ *mongo.Client, sp config.Space, pd *promoted,
+) error {
+	ms, err := decodeRaw[Material](pd.all())
+	if err != nil {
+		return err
+	}
+
+	return sendMaterialCreateMessage(ctx, mq, sp.Scope, sp.Env, ms)
+}
+
+// sendPromotedCategories 更新事件与 DealwithMaterialCategoryParentID 一致, 每个分类一条, 内容为 [原文档, 新文档]
+func sendPromotedCategories(
+	ctx context.Context, mq events.Publisher, _ *mongo.Client, sp config.Space, pd *promoted,
+) error {
+	cs, err := decodeRaw[Category](pd.created)
+	if err != nil {
+		return err
+	}
+	if len(cs) > 0 {
+		if err := sendCategoryCreateMessage(ctx, mq, sp.Scope, sp.Env, cs); err != nil {
+			return err
+		}
+	}
+
+	us, err := decodeRaw[Category](pd.updated)
+	if err != nil {
+		return err
+	}
+	if len(us) == 0 {
+		return nil
+	}
+	origins, err := decodeRaw[Category](pd.replaced)
+	if err != nil {
+		return err
+	}
+
+	updates := make([][]*Category, 0, len(us))
+	for i := range us {
+		updates = append(updates, []*Category{origins[i], us[i]})
+	}
+
+	return sendCategoryUpdateMessage(ctx, mq, sp.Scope, sp.Env, updates)
+}
+
+func sendPromotedPlans(
+	ctx context.Context, mq events.Publisher, _ *mongo.Client, sp config.Space, pd *promoted,
+) error {
+	ps, err := decodeRaw[Plan](pd.all())
+	if err != nil {
+		return err
+	}
+
+	return sendMaterialPlanCreateMessage(ctx, mq, sp.Scope, sp.Env, ps)
+}
+
+func sendPromotedPositions(
+	ctx context.Context, mq events.Publisher, _ *mongo.Client, sp config.Space, pd *promoted,
+) error {
+	ps, err := decodeRaw[MaterialPosition](pd.all())
+	if err != nil {
+		return err
+	}
+
+	return sendMaterialPositionCreateMessage(ctx, mq, sp.Scope, sp.Env, ps)
+}
+
+// h5Properties h5 样式, 字段与 h5 库 properties 集合一致
+type h5Properties struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Attribute  string             `bson:"attribute"`
+	Style      string             `bson:"style"`
+	ActivityID string             `bson:"activityID"`
+}
+
+// sendPromotedH5Properties 事件需要带上目标环境中物料的名称
+func sendPromotedH5Properties(
+	ctx context.Context, mq events.Publisher, client *mongo.Client, sp config.Space, pd *promoted,
+) error {
+	ps, err := decodeRaw[h5Properties](pd.all())
+	if err != nil {
+		return err
+	}
+
+	actColl := client.Database(sp.DBName("operational-positions")).Collection("activity")
+	msg := make([]*H5PropertiesWithActName, 0, len(ps))
+	for _, p := range ps {
+		name := ""
+		if actID, err := primitive.ObjectIDFromHex(p.ActivityID); err == nil {
+			var act struct {
+				Name string `bson:"name"`
+			}
+			err := actColl.FindOne(ctx, bson.M{"_id": actID}, options.FindOne().SetProjection(bson.M{"name": 1})).Decode(&act)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			name = act.Name
+		}
+
+		msg = append(msg, &H5PropertiesWithActName{
+			ID:        p.ID.Hex(),
+			Attribute: p.Attribute,
+			Style:     p.Style,
+			ActID:     p.ActivityID,
+			ActName:   name,
+		})
+	}
+
+	return SendOperitionPositionCreateMesssage(ctx, mq, sp.Scope, sp.Env, msg)
+}
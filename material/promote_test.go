@@ -0,0 +1,24 @@
+package material
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPromoteFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	p := &PromoteOptions{IDs: []string{id.Hex(), "legacy"}}
+
+	f := p.filter()
+	in := f["_id"].(bson.M)["$in"].(bson.A)
+	if in[0] != id || in[1] != "legacy" {
+		t.Errorf("$in = %v, want the ObjectID and the raw string", in)
+	}
+
+	p.Filter = bson.M{"type": "sticker"}
+	if f = p.filter(); len(f["$and"].(bson.A)) != 2 {
+		t.Errorf("filter = %v, want ids and filter combined", f)
+	}
+}
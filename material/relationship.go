@@ -4,34 +4,98 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
 
 	"github.com/pinguo-icc/go-lib/v2/dao"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// BmallPlatforms 素材自定义属性中 bmall 各平台ID的 code
+var BmallPlatforms = []string{"bmallId", "bmallIdArd"}
+
+// PlatformStats 一个平台 bmall ID 的映射统计
+type PlatformStats struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+	// Invalid bmall ID 不是合法的 ObjectID
+	Invalid int
+	// Missing 素材未设置该平台的 bmall ID
+	Missing int
+	// Conflict 同一 bmall ID 在本次执行中被多个素材使用, 只保留第一个
+	Conflict int
+	Failed   int
+}
+
+// RelationshipResult CreateMapBetweenBmallAndOpsID 的执行结果
+type RelationshipResult struct {
+	Materials int
+	Platforms map[string]*PlatformStats
+}
+
+func newRelationshipResult() *RelationshipResult {
+	res := &RelationshipResult{Platforms: map[string]*PlatformStats{}}
+	for _, p := range BmallPlatforms {
+		res.Platforms[p] = &PlatformStats{}
+	}
+
+	return res
+}
+
+// Print 按平台输出统计
+func (r *RelationshipResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d materials with bmall ids\n", r.Materials)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "PLATFORM\tINSERTED\tUPDATED\tUNCHANGED\tINVALID\tMISSING\tCONFLICT\tFAILED\n")
+	for _, p := range BmallPlatforms {
+		s := r.Platforms[p]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			p, s.Inserted, s.Updated, s.Unchanged, s.Invalid, s.Missing, s.Conflict, s.Failed)
+	}
+	tw.Flush()
+}
+
 // CreateMapBetweenBmallAndOpsID 建立从 bmall 迁移的素材的id的映射关系
-func CreateMapBetweenBmallAndOpsID(ctx context.Context, ops, bmall *mongo.Client) error {
-	opsDBName := "camera360_prod_operational_materials"
+// 无效及冲突的映射写入 opt.Report, dry-run 时只统计不写入
+func CreateMapBetweenBmallAndOpsID(ctx context.Context, ops, bmall *mongo.Client, opt *Options) (*RelationshipResult, error) {
+	spaces := opt.Matrix.Spaces()
+	if len(spaces) != 1 {
+		return nil, fmt.Errorf("bmall relationship must target exactly one scope/env, got %v", spaces)
+	}
+
+	opsDBName := spaces[0].DBName("operational_materials")
 	bmallDBName := "bmall"
 	opsDao := dao.NewMongodbDAO(ops.Database(opsDBName), "material")
 	bmallDao := dao.NewMongodbDAO(bmall.Database(bmallDBName), "id_relationship")
 
+	rs := &relationshipSync{
+		opsColl:   opsDao.Collection(),
+		bmallColl: bmallDao.Collection(),
+		rep:       opt.Report,
+		scope:     spaces[0].Scope,
+		env:       spaces[0].Env,
+		res:       newRelationshipResult(),
+		seen:      map[primitive.ObjectID]string{},
+	}
+
 	// 在ops中分叶查找有bmallID 或者有 bmallIDArd 的素材
 	fOpt := &FindBmallOptions{}
 	hasNext := true
 	page := 1
 
 	for hasNext {
-		fmt.Println("process ==> ", page)
+		log.Printf("process page %d\n", page)
 		fOpt.Pagination().SetPage(int32(page)).SetPageSize(20)
 		res := make([]*Material, 0, 20)
 		err := opsDao.Find(ctx, &res, fOpt)
 		if err != nil {
-			fmt.Println("load materials data occur error: ", err.Error(), "page: ", page, "pageSize: ", 20)
-
-			return err
+			return rs.res, fmt.Errorf("load materials page %d: %w", page, err)
 		}
 		total := int32(0)
 		if fOpt.Pagination() != nil {
@@ -41,66 +105,113 @@ func CreateMapBetweenBmallAndOpsID(ctx context.Context, ops, bmall *mongo.Client
 		hasNext = total > int32(page)
 		page++
 
-		syncMaterialsID(res, bmallDao)
+		for _, m := range res {
+			if err := rs.material(ctx, m); err != nil {
+				return rs.res, err
+			}
+		}
 	}
 
-	fmt.Println("total:", gTotal, "repeated:", gRepeated, "materialsCount: ", mCount, "notOk:", gNotOk)
+	return rs.res, nil
+}
 
-	return nil
+// relationshipSync 一次映射同步的状态
+type relationshipSync struct {
+	opsColl, bmallColl *mongo.Collection
+	rep                *report.Report
+	scope, env         string
+	res                *RelationshipResult
+	// seen 本次已处理的 bmall ID 及对应的素材ID
+	seen map[primitive.ObjectID]string
 }
 
-func syncMaterialsID(materials []*Material, bmallDao dao.MongodbDAO) {
-	for _, m := range materials {
-		mCount++
-		if m.IsDeleted {
+func (rs *relationshipSync) fail(opsID, platform, class string, err error) {
+	rs.rep.Fail(rs.opsColl, opsID, report.Record{
+		Kind: report.KindMaterial, Field: platform, Class: class, Scope: rs.scope, Env: rs.env,
+	}, err)
+}
+
+// material 保存素材各平台的映射, 仅查询 bmall 出错时返回错误
+func (rs *relationshipSync) material(ctx context.Context, m *Material) error {
+	if m.IsDeleted {
+		return nil
+	}
+	rs.res.Materials++
+
+	for _, p := range BmallPlatforms {
+		st := rs.res.Platforms[p]
+		r, err := GenRelationshipID(m, p)
+		if err != nil {
+			st.Invalid++
+			rs.fail(m.ID.Hex(), p, report.ClassValidation, err)
+
 			continue
 		}
-		res := GenRelationshipID(m)
-		if len(res) > 0 {
-			for _, r := range res {
-				saveRelationshipID(r, bmallDao)
+		if r == nil {
+			st.Missing++
+
+			continue
+		}
+
+		if other, ok := rs.seen[r.BmallID]; ok {
+			if other != r.OpsID {
+				st.Conflict++
+				rs.fail(r.OpsID, p, report.ClassConflict,
+					fmt.Errorf("bmall id %s is already mapped to material %s", r.BmallID.Hex(), other))
 			}
+
+			continue
+		}
+		rs.seen[r.BmallID] = r.OpsID
+
+		if err := rs.save(ctx, r, st); err != nil {
+			return err
 		}
 	}
-}
 
-var gTotal = 0
-var gRepeated = 0
-var gNotOk = 0
-var mCount = 0
+	return nil
+}
 
-func saveRelationshipID(r *RelationshipID, bmallDao dao.MongodbDAO) {
-	ctx := context.Background()
+// save 写入映射, bmall 中已有指向其它素材的映射时更新并记为冲突
+func (rs *relationshipSync) save(ctx context.Context, r *RelationshipID, st *PlatformStats) error {
 	or := &RelationshipID{}
-	err := bmallDao.Collection().FindOne(ctx, primitive.M{"_id": r.BmallID}).Decode(or)
+	err := rs.bmallColl.FindOne(ctx, primitive.M{"_id": r.BmallID}).Decode(or)
 	if err != nil {
 		if !errors.Is(err, mongo.ErrNoDocuments) {
-			fmt.Println("saveRelationshipID: ", err.Error(), "id: ", r.BmallID.Hex())
-
-			return
+			return fmt.Errorf("find relationship %s: %w", r.BmallID.Hex(), err)
 		}
-		// 执行插入操作
-		if _, err := bmallDao.Collection().InsertOne(ctx, r); err != nil {
-			fmt.Println("saveRelationshipID.insert : ", err.Error(), "id: ", r.BmallID.Hex())
 
-			return
+		if _, err := store.Wrap(rs.bmallColl).InsertOne(ctx, r); err != nil {
+			st.Failed++
+			rs.fail(r.OpsID, r.Platform, "", err)
+
+			return nil
 		}
+		st.Inserted++
 
-		return
+		return nil
 	}
 
-	// 执行更新操作
-	if r.BmallID == or.BmallID && r.OpsID == or.OpsID {
-		gRepeated++
-		return
+	if r.OpsID == or.OpsID {
+		st.Unchanged++
+
+		return nil
 	}
 
-	fmt.Println("need update: new-> ", r.BmallID.Hex(), r.OpsID, "old-> ", or.BmallID.Hex(), or.OpsID)
+	rs.fail(r.OpsID, r.Platform, report.ClassConflict,
+		fmt.Errorf("bmall id %s was mapped to material %s", r.BmallID.Hex(), or.OpsID))
 
-	_, err = bmallDao.Collection().UpdateByID(ctx, r.BmallID, primitive.M{"$set": primitive.M{"opsID": r.OpsID}}, options.Update().SetUpsert(true))
+	_, err = store.Wrap(rs.bmallColl).UpdateByID(ctx, r.BmallID,
+		primitive.M{"$set": primitive.M{"opsID": r.OpsID}}, options.Update().SetUpsert(true))
 	if err != nil {
-		fmt.Println("saveRelationshipID.update : ", err.Error(), "id: ", r.BmallID.Hex())
+		st.Failed++
+		rs.fail(r.OpsID, r.Platform, "", err)
+
+		return nil
 	}
+	st.Updated++
+
+	return nil
 }
 
 type FindBmallOptions struct {
@@ -136,50 +247,27 @@ func (opt *FindBmallOptions) Sorts() dao.SortFields {
 type RelationshipID struct {
 	BmallID primitive.ObjectID `bson:"_id"`
 	OpsID   string             `bson:"opsID"`
+	// Platform 映射来源的自定义属性 code, 不写入
+	Platform string `bson:"-"`
 }
 
-func GenRelationshipID(m *Material) []*RelationshipID {
+// GenRelationshipID 返回素材指定平台的映射, 未设置该平台的 bmall ID 时返回nil
+func GenRelationshipID(m *Material, platform string) (*RelationshipID, error) {
 	if m == nil || len(m.Versions) == 0 {
-		fmt.Errorf("can't get material id relationship id, maybe material data error")
-		return nil
+		return nil, errors.New("can't get material id relationship id, maybe material data error")
 	}
+
 	opsID := m.ID.Hex()
-	res := []*RelationshipID{}
-	if bmallId, ok := m.Versions[0].Custom["bmallId"]; ok {
-		if bid := bmallId.GetText(); bid != "" {
-			oid, err := primitive.ObjectIDFromHex(bid)
-			if err != nil {
-				fmt.Println("material: "+opsID+" bmallId: "+bid+" is invalidate.", err.Error())
-			} else {
-				res = append(res, &RelationshipID{BmallID: oid, OpsID: opsID})
-			}
-			gTotal++
-		} else {
-			gTotal++
-			gNotOk++
-		}
-	} else {
-		gTotal++
-		gNotOk++
+	fv, ok := m.Versions[0].Custom[platform]
+	if !ok || fv == nil || fv.GetText() == "" {
+		return nil, nil
 	}
 
-	if bmallArdId, ok := m.Versions[0].Custom["bmallIdArd"]; ok {
-		if bid := bmallArdId.GetText(); bid != "" {
-			oid, err := primitive.ObjectIDFromHex(bid)
-			if err != nil {
-				fmt.Println("material: "+opsID+" bmallId: "+bid+" is invalidate.", err.Error())
-			} else {
-				res = append(res, &RelationshipID{BmallID: oid, OpsID: opsID})
-			}
-			gTotal++
-		} else {
-			gTotal++
-			gNotOk++
-		}
-	} else {
-		gTotal++
-		gNotOk++
+	bid := fv.GetText()
+	oid, err := primitive.ObjectIDFromHex(bid)
+	if err != nil {
+		return nil, fmt.Errorf("material %s %s %q is invalid: %w", opsID, platform, bid, err)
 	}
 
-	return res
+	return &RelationshipID{BmallID: oid, OpsID: opsID, Platform: platform}, nil
 }
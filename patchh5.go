@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/pinguo-icc/kratos-library/mongo/op"
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/executor"
+	"github.com/pinguo-icc/mongodbcli/h5patch"
+	"github.com/pinguo-icc/mongodbcli/report"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	rulesFile := ""
+
+	register(&command{
+		name:   "patch-h5-properties",
+		desc:   "apply the set/unset/rename operations of a rule file to the style and attribute of h5 properties",
+		conns:  connMongo,
+		writes: []string{"h5"},
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&rulesFile, "rules", "", "yaml or json rule file, e.g. h5patch/pos-center.yaml")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			if rulesFile == "" {
+				return errors.New("please set --rules")
+			}
+			rules, err := h5patch.Load(rulesFile)
+			if err != nil {
+				return err
+			}
+
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			units := []executor.Unit{}
+			for _, sp := range opt.Matrix.Spaces() {
+				sp := sp
+				units = append(units, executor.Unit{
+					Name: sp.String(),
+					Run: func(ctx context.Context, st *executor.Stats) error {
+						return patchH5Properties(ctx, rt.mongo, sp, rules, rt.report, st)
+					},
+				})
+			}
+
+			return opt.Run(ctx, units)
+		},
+	})
+}
+
+// patchH5Properties 对空间中未删除的 h5 物料的样式执行规则
+func patchH5Properties(
+	ctx context.Context, client *mongo.Client, sp config.Space, rules *h5patch.Rules, rep *report.Report, st *executor.Stats,
+) error {
+	actColl := client.Database(sp.DBName("operational-positions")).Collection("activity")
+	h5Coll := client.Database(sp.DBName("h5")).Collection("properties")
+
+	cur, err := actColl.Find(ctx, primitive.M{
+		"type":      op.In([]int{2, 3}),
+		"isDeleted": false,
+	}, options.Find().SetProjection(primitive.M{"_id": 1, "fieldDefCode": 1}))
+	if err != nil {
+		return err
+	}
+
+	var acts []*Activity
+	if err := cur.All(ctx, &acts); err != nil {
+		return err
+	}
+
+	codes := make(map[string]string, len(acts))
+	for _, a := range acts {
+		codes[a.ID.Hex()] = a.FieldDefCode
+	}
+
+	cur, err = h5Coll.Find(ctx, primitive.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		pro := H5Properties{}
+		if err := cur.Decode(&pro); err != nil {
+			return err
+		}
+
+		code, ok := codes[pro.ActivityID]
+		if !ok {
+			continue
+		}
+
+		doc := &h5patch.Doc{FieldDefCode: code, Style: pro.Style, Attribute: pro.Attribute}
+		applied, err := rules.Apply(doc)
+		if err != nil {
+			st.Failed++
+			rep.Fail(h5Coll, pro.ID.Hex(), report.Record{Kind: report.KindH5Properties, Class: report.ClassValidation}, err)
+
+			continue
+		}
+		if len(applied) == 0 {
+			continue
+		}
+
+		update := primitive.M{"style": doc.Style, "attribute": doc.Attribute}
+		if _, err := store.Wrap(h5Coll).UpdateByID(ctx, pro.ID, op.Set(update)); err != nil {
+			st.Failed++
+			rep.Fail(h5Coll, pro.ID.Hex(), report.Record{Kind: report.KindH5Properties}, err)
+
+			continue
+		}
+
+		st.Processed++
+		log.Printf("h5 properties %s of activity %s patched by %s\n", pro.ID.Hex(), pro.ActivityID, strings.Join(applied, ", "))
+	}
+
+	return cur.Err()
+}
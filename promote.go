@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/pinguo-icc/mongodbcli/config"
+	"github.com/pinguo-icc/mongodbcli/material"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func init() {
+	var (
+		kind, ids, filter string
+		p                 = &material.PromoteOptions{}
+	)
+
+	register(&command{
+		name:  "promote",
+		desc:  "copy selected documents of the source env into the other envs of the same scope, keeping their ids",
+		conns: connMongo,
+		// 只记录 --kind 对应的库, 源环境不写入
+		targets: func() (writes, sourceEnvs []string) {
+			if db := material.PromoteDB(kind); db != "" {
+				writes = []string{db}
+			}
+
+			return writes, []string{p.SourceEnv}
+		},
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&kind, "kind", "", "what to promote: "+strings.Join(material.PromoteKinds(), ", "))
+			fs.StringVar(&p.SourceEnv, "source-env", "operation", "the env documents are copied from, every other --env is a target")
+			fs.StringVar(&ids, "ids", "", "comma separated _id of the documents to promote")
+			fs.StringVar(&filter, "filter", "", `extended JSON filter of the documents to promote, e.g. {"type":"sticker"}`)
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			opt, err := materialOptions(rt)
+			if err != nil {
+				return err
+			}
+
+			p.Kind = kind
+			p.IDs = config.SplitList(ids)
+			if filter != "" {
+				if err := bson.UnmarshalExtJSON([]byte(filter), false, &p.Filter); err != nil {
+					return fmt.Errorf("invalid --filter: %w", err)
+				}
+			}
+
+			return material.Promote(ctx, rt.mongo, opt, p)
+		},
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/store"
+)
+
+func init() {
+	var (
+		file  string
+		batch int
+	)
+
+	register(&command{
+		name: "replay-events",
+		desc: "publish the events captured with --events-file to the kafka of --mq-profile",
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&file, "file", "", "the NDJSON event file to publish")
+			fs.IntVar(&batch, "batch", 100, "how many events are sent in one request")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			if file == "" {
+				return errors.New("please set --file")
+			}
+
+			return replayEvents(ctx, rt, file, batch)
+		},
+	})
+}
+
+// replayEvents 按文件顺序重发事件, dry-run 时只统计
+func replayEvents(ctx context.Context, rt *runtime, file string, batch int) error {
+	if batch < 1 {
+		batch = 1
+	}
+
+	var pub events.Publisher
+	if !store.DryRun() {
+		p, err := rt.mqProfile()
+		if err != nil {
+			return err
+		}
+		k, cancel, err := events.NewKafka(p)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		pub = k
+	}
+
+	buf := make([]*events.Envelope, 0, batch)
+	sent, first := 0, 0
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if err := pub.Publish(ctx, buf...); err != nil {
+			return fmt.Errorf("publish events from line %d failed, %d events before it were sent: %w", first, sent, err)
+		}
+		sent += len(buf)
+		buf = buf[:0]
+
+		return nil
+	}
+
+	err := events.Read(file, func(line int, e *events.Envelope) error {
+		if store.DryRun() {
+			store.RecordEvents(e.Headers["scope"], e.Headers["env"], e.Topic, 1)
+
+			return nil
+		}
+
+		if len(buf) == 0 {
+			first = line
+		}
+		buf = append(buf, e)
+		if len(buf) < batch {
+			return nil
+		}
+
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("replay %s: %d events sent", file, sent)
+
+	return nil
+}
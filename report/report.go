@@ -0,0 +1,347 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Kind 记录对应的数据类型
+const (
+	KindMaterial         = "material"
+	KindCategory         = "category"
+	KindMaterialPosition = "material_position"
+	KindPlan             = "plan"
+	KindActivity         = "activity"
+	KindH5Properties     = "h5_properties"
+	KindIndex            = "index"
+)
+
+// Class 错误分类, 用于汇总
+const (
+	ClassFieldDefinition = "field_definition"
+	ClassValidation      = "validation"
+	ClassConversion      = "conversion"
+	ClassCoercion        = "coercion"
+	ClassConflict        = "conflict"
+	ClassDuplicateKey    = "duplicate_key"
+	ClassTimeout         = "timeout"
+	ClassNetwork         = "network"
+	ClassCanceled        = "canceled"
+	ClassWrite           = "write"
+	ClassOther           = "other"
+)
+
+// Format 报告文件格式
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Record 一条处理失败的记录
+type Record struct {
+	RunID      string `json:"runID"`
+	Action     string `json:"action"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	DocID      string `json:"docID"`
+	Kind       string `json:"kind"`
+	// Field 出错的字段 code, 仅 validation、conversion、coercion 类错误有值
+	Field string `json:"field,omitempty"`
+	// Page 源数据的页码, 从1开始, 0 表示不分页
+	Page    int    `json:"page"`
+	Class   string `json:"class"`
+	Message string `json:"message"`
+	Scope   string `json:"scope"`
+	Env     string `json:"env"`
+}
+
+var header = []string{
+	"run_id", "action", "database", "collection", "doc_id", "kind", "field", "page", "class", "message", "scope", "env",
+}
+
+func (r *Record) row() []string {
+	return []string{
+		r.RunID, r.Action, r.Database, r.Collection, r.DocID, r.Kind, r.Field,
+		strconv.Itoa(r.Page), r.Class, r.Message, r.Scope, r.Env,
+	}
+}
+
+// Report 一次执行的失败记录, 可被多个单元并发写入
+type Report struct {
+	mu      sync.Mutex
+	runID   string
+	action  string
+	records []*Record
+}
+
+// New runID 为空时(未连接台账)生成一个新的ID, 用于区分报告文件
+func New(runID, action string) *Report {
+	if runID == "" {
+		runID = primitive.NewObjectID().Hex()
+	}
+
+	return &Report{runID: runID, action: action}
+}
+
+// Fail 记录源集合 coll 中文档 id 的失败, rec.Class 为空时按错误类型归类
+// Report 为nil时不记录
+func (r *Report) Fail(coll *mongo.Collection, id string, rec Record, err error) {
+	if r == nil {
+		return
+	}
+
+	rec.RunID, rec.Action = r.runID, r.action
+	rec.Database, rec.Collection = coll.Database().Name(), coll.Name()
+	rec.DocID = id
+	rec.Message = err.Error()
+	if rec.Class == "" {
+		rec.Class = Classify(err)
+	}
+
+	r.mu.Lock()
+	r.records = append(r.records, &rec)
+	r.mu.Unlock()
+}
+
+// Classify 按 mongo 驱动的错误类型归类
+func Classify(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case mongo.IsDuplicateKeyError(err):
+		return ClassDuplicateKey
+	case mongo.IsTimeout(err):
+		return ClassTimeout
+	case mongo.IsNetworkError(err):
+		return ClassNetwork
+	}
+
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		return ClassWrite
+	}
+
+	return ClassOther
+}
+
+// Records 按库、集合、文档排序后返回全部记录
+func (r *Report) Records() []*Record {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	res := append([]*Record(nil), r.records...)
+	r.mu.Unlock()
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+
+		return a.DocID < b.DocID
+	})
+
+	return res
+}
+
+// ParseFormats 解析逗号分隔的报告格式
+func ParseFormats(s string) ([]string, error) {
+	res := []string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch f {
+		case "":
+			continue
+		case FormatJSON, FormatNDJSON, FormatCSV:
+			res = append(res, f)
+		default:
+			return nil, fmt.Errorf("unknown report format %q, use json, ndjson or csv", f)
+		}
+	}
+
+	return res, nil
+}
+
+// Write 在 dir 下按格式写出 <action>_<runID>.<format>, 没有记录时不写, 返回写出的文件
+func (r *Report) Write(dir string, formats []string) ([]string, error) {
+	recs := r.Records()
+	if len(recs) == 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, f := range formats {
+		name := filepath.Join(dir, fmt.Sprintf("%s_%s.%s", r.action, r.runID, f))
+		if err := writeFile(name, f, recs); err != nil {
+			return files, fmt.Errorf("write report %s: %w", name, err)
+		}
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+func writeFile(name, format string, recs []*Record) (err error) {
+	fh, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return encode(fh, format, recs)
+}
+
+func encode(w io.Writer, format string, recs []*Record) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(recs)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, rec := range recs {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		rows := make([][]string, 0, len(recs)+1)
+		rows = append(rows, header)
+		for _, rec := range recs {
+			rows = append(rows, rec.row())
+		}
+
+		// WriteAll 内部已 Flush 并返回写入错误
+		return cw.WriteAll(rows)
+	}
+
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// PrintSummary 按错误分类汇总输出, 每类附带一条示例
+func (r *Report) PrintSummary(w io.Writer) {
+	recs := r.Records()
+	if len(recs) == 0 {
+		return
+	}
+
+	type group struct {
+		class   string
+		count   int
+		kinds   map[string]bool
+		example string
+	}
+	groups := map[string]*group{}
+	for _, rec := range recs {
+		g, ok := groups[rec.Class]
+		if !ok {
+			g = &group{class: rec.Class, kinds: map[string]bool{}, example: rec.Message}
+			groups[rec.Class] = g
+		}
+		g.count++
+		g.kinds[rec.Kind] = true
+	}
+
+	list := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+
+		return list[i].class < list[j].class
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CLASS\tCOUNT\tKINDS\tEXAMPLE\n")
+	for _, g := range list {
+		kinds := make([]string, 0, len(g.kinds))
+		for k := range g.kinds {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", g.class, g.count, strings.Join(kinds, ","), g.example)
+	}
+	tw.Flush()
+}
+
+// PrintFieldSummary 按数据类型及字段 code 汇总校验失败的记录
+func (r *Report) PrintFieldSummary(w io.Writer) {
+	type group struct {
+		kind, field string
+		count       int
+		example     *Record
+	}
+	groups := map[string]*group{}
+	list := []*group{}
+	for _, rec := range r.Records() {
+		if rec.Class != ClassValidation {
+			continue
+		}
+
+		key := rec.Kind + "/" + rec.Field
+		g, ok := groups[key]
+		if !ok {
+			g = &group{kind: rec.Kind, field: rec.Field, example: rec}
+			groups[key] = g
+			list = append(list, g)
+		}
+		g.count++
+	}
+	if len(list) == 0 {
+		return
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		if list[i].kind != list[j].kind {
+			return list[i].kind < list[j].kind
+		}
+
+		return list[i].field < list[j].field
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "KIND\tFIELD\tCOUNT\tEXAMPLE DOC\tEXAMPLE\n")
+	for _, g := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s.%s/%s\t%s\n",
+			g.kind, g.field, g.count, g.example.Database, g.example.Collection, g.example.DocID, g.example.Message)
+	}
+	tw.Flush()
+}
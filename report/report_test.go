@@ -0,0 +1,66 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWrite(t *testing.T) {
+	// 仅用于取库名、集合名, 不会建立连接
+	cli, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := cli.Database("camera360_qa_operations_materials").Collection("material")
+
+	r := New("run1", "syncMaterials")
+	r.Fail(coll, "b", Record{Kind: KindMaterial, Page: 2, Class: ClassFieldDefinition, Scope: "camera360", Env: "qa"},
+		errors.New("field define not found"))
+	r.Fail(coll, "a", Record{Kind: KindMaterial, Page: 1, Scope: "camera360", Env: "qa"}, errors.New("boom"))
+
+	dir := t.TempDir()
+	files, err := r.Write(dir, []string{FormatCSV, FormatNDJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0], "syncMaterials_run1.csv") {
+		t.Fatalf("files = %v", files)
+	}
+
+	fh, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	rows, err := csv.NewReader(fh).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "run_id" {
+		t.Fatalf("rows = %v, want a header and 2 records", rows)
+	}
+	if got := strings.Join(rows[1], "|"); got != "run1|syncMaterials|camera360_qa_operations_materials|material|a|material||1|other|boom|camera360|qa" {
+		t.Errorf("first row = %s", got)
+	}
+
+	b, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 2 {
+		t.Errorf("ndjson lines = %d, want 2", n)
+	}
+
+	var out bytes.Buffer
+	r.PrintSummary(&out)
+	if !strings.Contains(out.String(), ClassFieldDefinition) || !strings.Contains(out.String(), ClassOther) {
+		t.Errorf("summary = %s", out.String())
+	}
+}
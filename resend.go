@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pinguo-icc/mongodbcli/events"
+	"github.com/pinguo-icc/mongodbcli/store"
+)
+
+func init() {
+	var (
+		runID   string
+		retries int
+		backoff time.Duration
+	)
+
+	register(&command{
+		name:  "resend-failed",
+		desc:  "resend the events in the dead letters to the kafka of --mq-profile",
+		conns: connMongo,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&runID, "run", "", "only resend the dead letters of this run, default all")
+			fs.IntVar(&retries, "retries", 5, "how many times an event is retried")
+			fs.DurationVar(&backoff, "backoff", time.Second, "the wait before the first retry, doubled after each failure")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			return resendFailed(ctx, rt, runID, retries, backoff)
+		},
+	})
+}
+
+// resendFailed 逐条重发死信, 失败时指数退避重试, 最后输出仍未送达的事件
+func resendFailed(ctx context.Context, rt *runtime, runID string, retries int, backoff time.Duration) error {
+	dl := events.NewDeadLetters(rt.mongo, rt.opt.AdminDB, runID, rt.action)
+	letters, err := dl.Pending(ctx, runID)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d dead letters pending", len(letters))
+
+	if store.DryRun() {
+		for _, l := range letters {
+			store.RecordEvents(l.Headers["scope"], l.Headers["env"], l.Topic, 1)
+		}
+
+		return nil
+	}
+
+	p, err := rt.mqProfile()
+	if err != nil {
+		return err
+	}
+	k, cancel, err := events.NewKafka(p)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	failed := []*events.DeadLetter{}
+	for _, l := range letters {
+		attempts, err := resend(ctx, k, l.Envelope(), retries, backoff)
+		if rerr := dl.Result(ctx, l, attempts, err); rerr != nil {
+			log.Printf("update dead letter %s failed: %v", l.ID.Hex(), rerr)
+		}
+		if err != nil {
+			l.Error = err.Error()
+			failed = append(failed, l)
+		}
+	}
+
+	log.Printf("%d dead letters delivered, %d undelivered", len(letters)-len(failed), len(failed))
+	if len(failed) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "DEAD LETTER\tRUN\tTOPIC\tKEY\tERROR\n")
+	for _, l := range failed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.ID.Hex(), l.RunID, l.Topic, l.Key, l.Error)
+	}
+	tw.Flush()
+
+	return fmt.Errorf("%d events remain undelivered", len(failed))
+}
+
+// resend 发送单条事件, 返回尝试次数
+func resend(ctx context.Context, pub events.Publisher, e *events.Envelope, retries int, backoff time.Duration) (int, error) {
+	var err error
+	attempts := 0
+	for wait := backoff; attempts <= retries; wait *= 2 {
+		attempts++
+		if err = pub.Publish(ctx, e); err == nil {
+			return attempts, nil
+		}
+		if attempts > retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return attempts, err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/pinguo-icc/mongodbcli/backup"
+	"github.com/pinguo-icc/mongodbcli/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	var runID, from string
+
+	register(&command{
+		name:  "rollback",
+		desc:  "restore the documents changed by a run from its --backup snapshots",
+		conns: connMongo,
+		setFlags: func(fs *flag.FlagSet) {
+			fs.StringVar(&runID, "run", "", "the run id to roll back, printed at start and kept in the ledger")
+			fs.StringVar(&from, "from", backup.TargetDB, "where the snapshots are: db for the admin database, otherwise the --backup directory")
+		},
+		run: func(ctx context.Context, rt *runtime) error {
+			if runID == "" {
+				return errors.New("please set --run")
+			}
+
+			return rollback(ctx, rt, runID, from)
+		},
+	})
+}
+
+// rollback 将一次执行修改过的文档恢复为执行前的原貌, 执行中新建的文档会被删除
+func rollback(ctx context.Context, rt *runtime, runID, from string) error {
+	imgs, err := backup.Load(ctx, from, runID, rt.mongo, rt.opt.AdminDB)
+	if err != nil {
+		return err
+	}
+	if len(imgs) == 0 {
+		return fmt.Errorf("no snapshot found for run %s in %s", runID, from)
+	}
+
+	restored, deleted, failed := 0, 0, 0
+	for _, img := range imgs {
+		cli, err := rollbackClient(ctx, rt, img.Conn)
+		if err != nil {
+			return err
+		}
+
+		coll := store.Wrap(cli.Database(img.Database).Collection(img.Collection))
+		filter := primitive.M{"_id": img.DocID}
+		if img.Existed {
+			_, err = coll.ReplaceOne(ctx, filter, img.Doc, options.Replace().SetUpsert(true))
+		} else {
+			_, err = coll.DeleteOne(ctx, filter)
+		}
+
+		switch {
+		case err != nil:
+			failed++
+			log.Printf("rollback %s.%s %v failed: %v", img.Database, img.Collection, img.DocID, err)
+		case img.Existed:
+			restored++
+		default:
+			deleted++
+		}
+	}
+
+	log.Printf("rollback run %s: %d restored, %d deleted, %d failed", runID, restored, deleted, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d documents of run %s failed to roll back", failed, runID)
+	}
+
+	return nil
+}
+
+// rollbackClient 按快照记录的连接名选择客户端, bmall 连接按需建立
+func rollbackClient(ctx context.Context, rt *runtime, conn string) (*mongo.Client, error) {
+	if conn != connBmall.String() {
+		return rt.mongo, nil
+	}
+
+	if rt.bmall == nil {
+		if rt.opt.BmallMondoDNS == "" {
+			return nil, errors.New("the run changed bmall documents, please set --bmall-mongo-dns")
+		}
+		if err := connect(ctx, rt, connBmall); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt.bmall, nil
+}
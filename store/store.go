@@ -0,0 +1,556 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var dryRun bool
+
+// SetDryRun 开启后所有经由 Collection 的写操作只统计匹配的文档数,不实际写入
+func SetDryRun(v bool) {
+	dryRun = v
+}
+
+// DryRun 是否处于 dry-run 模式
+func DryRun() bool {
+	return dryRun
+}
+
+// Snapshotter 写入前保存文档原貌, doc 为nil表示文档此前不存在(由本次写入新建)
+type Snapshotter interface {
+	Snapshot(ctx context.Context, c *mongo.Collection, id interface{}, doc bson.Raw) error
+}
+
+var snapshotter Snapshotter
+
+// SetSnapshotter 设置后所有经由 Collection 的实际写入都会先保存被修改文档的原貌
+func SetSnapshotter(s Snapshotter) {
+	snapshotter = s
+}
+
+// Collection 包装 *mongo.Collection 的写操作, 读操作直接使用内嵌的 Collection
+type Collection struct {
+	*mongo.Collection
+}
+
+// Wrap 包装集合, 需要受 dry-run 控制的写操作都应通过它执行
+func Wrap(c *mongo.Collection) *Collection {
+	return &Collection{Collection: c}
+}
+
+func (c *Collection) UpdateOne(
+	ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions,
+) (*mongo.UpdateResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, filter, 1); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.UpdateOne(ctx, filter, update, opts...)
+		c.countUpdate(res, err)
+		if err == nil {
+			err = c.snapshotNew(ctx, res.UpsertedID)
+		}
+
+		return res, err
+	}
+
+	return c.planUpdate(ctx, "updateOne", filter, 1, opts...)
+}
+
+func (c *Collection) UpdateByID(
+	ctx context.Context, id, update interface{}, opts ...*options.UpdateOptions,
+) (*mongo.UpdateResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, primitive.M{"_id": id}, 1); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.UpdateByID(ctx, id, update, opts...)
+		c.countUpdate(res, err)
+		if err == nil {
+			err = c.snapshotNew(ctx, res.UpsertedID)
+		}
+
+		return res, err
+	}
+
+	return c.planUpdate(ctx, "updateOne", primitive.M{"_id": id}, 1, opts...)
+}
+
+func (c *Collection) UpdateMany(
+	ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions,
+) (*mongo.UpdateResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, filter, 0); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.UpdateMany(ctx, filter, update, opts...)
+		c.countUpdate(res, err)
+		if err == nil {
+			err = c.snapshotNew(ctx, res.UpsertedID)
+		}
+
+		return res, err
+	}
+
+	return c.planUpdate(ctx, "updateMany", filter, 0, opts...)
+}
+
+func (c *Collection) DeleteOne(
+	ctx context.Context, filter interface{}, opts ...*options.DeleteOptions,
+) (*mongo.DeleteResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, filter, 1); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.DeleteOne(ctx, filter, opts...)
+		c.countDelete(res, err)
+
+		return res, err
+	}
+
+	n, err := c.count(ctx, filter, 1)
+	if err != nil {
+		return nil, err
+	}
+	record(c.Collection, "deleteOne", n, 0)
+
+	return &mongo.DeleteResult{DeletedCount: n}, nil
+}
+
+func (c *Collection) DeleteMany(
+	ctx context.Context, filter interface{}, opts ...*options.DeleteOptions,
+) (*mongo.DeleteResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, filter, 0); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.DeleteMany(ctx, filter, opts...)
+		c.countDelete(res, err)
+
+		return res, err
+	}
+
+	n, err := c.count(ctx, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	record(c.Collection, "deleteMany", n, 0)
+
+	return &mongo.DeleteResult{DeletedCount: n}, nil
+}
+
+func (c *Collection) InsertOne(
+	ctx context.Context, document interface{}, opts ...*options.InsertOneOptions,
+) (*mongo.InsertOneResult, error) {
+	if !dryRun {
+		res, err := c.Collection.InsertOne(ctx, document, opts...)
+		addCounts(c.Collection, func(cs *Counts) {
+			if err != nil {
+				cs.Failed++
+			} else {
+				cs.Inserted++
+			}
+		})
+		if err == nil {
+			err = c.snapshotNew(ctx, res.InsertedID)
+		}
+
+		return res, err
+	}
+
+	record(c.Collection, "insertOne", 0, 1)
+
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (c *Collection) ReplaceOne(
+	ctx context.Context, filter, replacement interface{}, opts ...*options.ReplaceOptions,
+) (*mongo.UpdateResult, error) {
+	if !dryRun {
+		if err := c.snapshot(ctx, filter, 1); err != nil {
+			return nil, err
+		}
+		res, err := c.Collection.ReplaceOne(ctx, filter, replacement, opts...)
+		c.countUpdate(res, err)
+		if err == nil {
+			err = c.snapshotNew(ctx, res.UpsertedID)
+		}
+
+		return res, err
+	}
+
+	uo := options.Update()
+	if ro := options.MergeReplaceOptions(opts...); ro.Upsert != nil {
+		uo.SetUpsert(*ro.Upsert)
+	}
+
+	return c.planUpdate(ctx, "replaceOne", filter, 1, uo)
+}
+
+// Replacement 按 _id 整体替换的文档, 不存在时插入
+type Replacement struct {
+	ID  interface{}
+	Doc interface{}
+}
+
+// ErrNotExecuted ordered 批量写入中排在失败操作之后、未被执行的文档
+var ErrNotExecuted = errors.New("not executed after an earlier failure in the ordered batch")
+
+// ReplaceMany 以一次 BulkWrite 按 _id upsert 替换 docs, ordered 为 false 时单个文档失败不影响其它文档
+// errs 与 docs 下标对应, 成功的文档为nil, 整批失败(如网络错误)时每个文档均为该错误;
+// err 为写入后记录新建文档的快照失败, 此时文档已写入, 但回滚时不会删除这些新建的文档
+func (c *Collection) ReplaceMany(ctx context.Context, docs []Replacement, ordered bool) (errs []error, err error) {
+	errs = make([]error, len(docs))
+	if len(docs) == 0 {
+		return errs, nil
+	}
+
+	ids := make(primitive.A, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d.ID)
+	}
+	filter := primitive.M{"_id": primitive.M{"$in": ids}}
+
+	if dryRun {
+		n, err := c.count(ctx, filter, 0)
+		if err != nil {
+			return fillErrs(errs, err), nil
+		}
+		record(c.Collection, "bulkReplace", n, int64(len(docs))-n)
+
+		return errs, nil
+	}
+
+	if err := c.snapshot(ctx, filter, 0); err != nil {
+		return fillErrs(errs, err), nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, d := range docs {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(primitive.M{"_id": d.ID}).SetReplacement(d.Doc).SetUpsert(true))
+	}
+	res, err := c.Collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	errs = bulkErrs(err, len(docs), ordered)
+
+	failed := int64(0)
+	for _, e := range errs {
+		if e != nil {
+			failed++
+		}
+	}
+	addCounts(c.Collection, func(cs *Counts) {
+		cs.Failed += failed
+		if res != nil {
+			cs.Matched += res.MatchedCount
+			cs.Modified += res.ModifiedCount
+			cs.Upserted += res.UpsertedCount
+		}
+	})
+
+	if res != nil {
+		for _, id := range res.UpsertedIDs {
+			if err := c.snapshotNew(ctx, id); err != nil {
+				return errs, err
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// bulkErrs 将 BulkWrite 返回的错误对应到 n 个操作上, 成功的操作为nil
+// WriteErrors 按 Index 对应; ordered 时首个失败之后的操作未执行, 记为 ErrNotExecuted;
+// WriteConcernError 及其它错误(如网络错误)记到尚无错误的全部操作上
+func bulkErrs(err error, n int, ordered bool) []error {
+	errs := make([]error, n)
+	if err == nil {
+		return errs
+	}
+
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		return fillErrs(errs, err)
+	}
+
+	first := n
+	for _, we := range bwe.WriteErrors {
+		if we.Index < 0 || we.Index >= n {
+			continue
+		}
+		errs[we.Index] = we
+		if we.Index < first {
+			first = we.Index
+		}
+	}
+	if ordered {
+		for i := first + 1; i < n; i++ {
+			if errs[i] == nil {
+				errs[i] = ErrNotExecuted
+			}
+		}
+	}
+	if bwe.WriteConcernError != nil {
+		fillErrs(errs, bwe.WriteConcernError)
+	}
+
+	return errs
+}
+
+// fillErrs 将 err 填入尚无错误的位置
+func fillErrs(errs []error, err error) []error {
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+	}
+
+	return errs
+}
+
+// CreateIndex 创建索引, dry-run 时只记录
+func (c *Collection) CreateIndex(
+	ctx context.Context, model mongo.IndexModel, opts ...*options.CreateIndexesOptions,
+) (string, error) {
+	if !dryRun {
+		return c.Collection.Indexes().CreateOne(ctx, model, opts...)
+	}
+
+	record(c.Collection, "createIndex", 0, 0)
+
+	return "", nil
+}
+
+// snapshot 保存将被修改的文档原貌, limit 为0时不限制
+func (c *Collection) snapshot(ctx context.Context, filter interface{}, limit int64) error {
+	if snapshotter == nil {
+		return nil
+	}
+
+	opt := options.Find()
+	if limit > 0 {
+		opt.SetLimit(limit)
+	}
+	cur, err := c.Collection.Find(ctx, filter, opt)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var id interface{}
+		if err := cur.Current.Lookup("_id").Unmarshal(&id); err != nil {
+			return err
+		}
+		if err := snapshotter.Snapshot(ctx, c.Collection, id, cur.Current); err != nil {
+			return fmt.Errorf("snapshot %s.%s %v: %w", c.Database().Name(), c.Name(), id, err)
+		}
+	}
+
+	return cur.Err()
+}
+
+// snapshotNew 记录本次写入新建的文档, 回滚时删除
+func (c *Collection) snapshotNew(ctx context.Context, id interface{}) error {
+	if snapshotter == nil || id == nil {
+		return nil
+	}
+
+	return snapshotter.Snapshot(ctx, c.Collection, id, nil)
+}
+
+func (c *Collection) planUpdate(
+	ctx context.Context, op string, filter interface{}, limit int64, opts ...*options.UpdateOptions,
+) (*mongo.UpdateResult, error) {
+	n, err := c.count(ctx, filter, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	upserts := int64(0)
+	uo := options.MergeUpdateOptions(opts...)
+	if n == 0 && uo.Upsert != nil && *uo.Upsert {
+		upserts = 1
+	}
+	record(c.Collection, op, n, upserts)
+
+	return &mongo.UpdateResult{MatchedCount: n, ModifiedCount: n, UpsertedCount: upserts}, nil
+}
+
+func (c *Collection) count(ctx context.Context, filter interface{}, limit int64) (int64, error) {
+	opt := options.Count()
+	if limit > 0 {
+		opt.SetLimit(limit)
+	}
+
+	return c.Collection.CountDocuments(ctx, filter, opt)
+}
+
+// Counts 单个库实际写入的统计
+type Counts struct {
+	Matched  int64 `bson:"matched"`
+	Modified int64 `bson:"modified"`
+	Upserted int64 `bson:"upserted"`
+	Deleted  int64 `bson:"deleted"`
+	Inserted int64 `bson:"inserted"`
+	Failed   int64 `bson:"failed"`
+}
+
+var stats = map[string]*Counts{}
+
+func addCounts(c *mongo.Collection, fn func(cs *Counts)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	db := c.Database().Name()
+	cs, ok := stats[db]
+	if !ok {
+		cs = &Counts{}
+		stats[db] = cs
+	}
+	fn(cs)
+}
+
+func (c *Collection) countUpdate(res *mongo.UpdateResult, err error) {
+	addCounts(c.Collection, func(cs *Counts) {
+		if err != nil {
+			cs.Failed++
+
+			return
+		}
+		cs.Matched += res.MatchedCount
+		cs.Modified += res.ModifiedCount
+		cs.Upserted += res.UpsertedCount
+	})
+}
+
+func (c *Collection) countDelete(res *mongo.DeleteResult, err error) {
+	addCounts(c.Collection, func(cs *Counts) {
+		if err != nil {
+			cs.Failed++
+
+			return
+		}
+		cs.Deleted += res.DeletedCount
+	})
+}
+
+// Stats 返回按库汇总的实际写入统计
+func Stats() map[string]Counts {
+	mu.Lock()
+	defer mu.Unlock()
+
+	res := make(map[string]Counts, len(stats))
+	for db, cs := range stats {
+		res[db] = *cs
+	}
+
+	return res
+}
+
+// planKey dry-run 计划按库、集合、操作汇总
+type planKey struct {
+	db, coll, op string
+}
+
+type planItem struct {
+	calls   int64
+	matched int64
+	inserts int64
+}
+
+type eventKey struct {
+	space, topic string
+}
+
+var (
+	mu     sync.Mutex
+	plan   = map[planKey]*planItem{}
+	events = map[eventKey]int{}
+)
+
+func record(c *mongo.Collection, op string, matched, inserts int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	k := planKey{db: c.Database().Name(), coll: c.Name(), op: op}
+	it, ok := plan[k]
+	if !ok {
+		it = &planItem{}
+		plan[k] = it
+	}
+	it.calls++
+	it.matched += matched
+	it.inserts += inserts
+}
+
+// RecordEvents 记录 dry-run 模式下将要发送的事件
+func RecordEvents(scope, env, topic string, n int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	events[eventKey{space: scope + "_" + env, topic: topic}] += n
+}
+
+// PrintPlan 按库输出 dry-run 模式下将要执行的写操作及事件
+func PrintPlan(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	keys := make([]planKey, 0, len(plan))
+	for k := range plan {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		if keys[i].coll != keys[j].coll {
+			return keys[i].coll < keys[j].coll
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	fmt.Fprintf(w, "======== dry-run plan, nothing has been written ========\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "DATABASE\tCOLLECTION\tOP\tCALLS\tMATCHED\tINSERTS\n")
+	for _, k := range keys {
+		it := plan[k]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\n", k.db, k.coll, k.op, it.calls, it.matched, it.inserts)
+	}
+	tw.Flush()
+
+	if len(events) == 0 {
+		return
+	}
+
+	eks := make([]eventKey, 0, len(events))
+	for k := range events {
+		eks = append(eks, k)
+	}
+	sort.Slice(eks, func(i, j int) bool {
+		if eks[i].space != eks[j].space {
+			return eks[i].space < eks[j].space
+		}
+		return eks[i].topic < eks[j].topic
+	})
+
+	fmt.Fprintf(w, "\n")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SPACE\tTOPIC\tEVENTS\n")
+	for _, k := range eks {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", k.space, k.topic, events[k])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBulkErrs(t *testing.T) {
+	dup := func(i int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Index: i, Code: 11000, Message: "duplicate key"}}
+	}
+	network := errors.New("connection reset")
+	wce := &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"}
+
+	cases := []struct {
+		name    string
+		err     error
+		ordered bool
+		// want 每个操作的结果: ok、write、skip(ErrNotExecuted)、wce、all(原错误)
+		want []string
+	}{
+		{"success", nil, false, []string{"ok", "ok", "ok"}},
+		{"unordered write errors", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(2), dup(0)}},
+			false, []string{"write", "ok", "write", "ok"}},
+		{"ordered stops at first failure", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(1)}},
+			true, []string{"ok", "write", "skip", "skip"}},
+		{"write concern error", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(1)}, WriteConcernError: wce},
+			false, []string{"wce", "write", "wce"}},
+		{"out of range index ignored", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(5)}},
+			false, []string{"ok", "ok"}},
+		{"whole batch failed", network, true, []string{"all", "all"}},
+	}
+
+	for _, c := range cases {
+		errs := bulkErrs(c.err, len(c.want), c.ordered)
+		if len(errs) != len(c.want) {
+			t.Fatalf("%s: %d errors, want %d", c.name, len(errs), len(c.want))
+		}
+		for i, w := range c.want {
+			e := errs[i]
+			var we mongo.BulkWriteError
+			ok := false
+			switch w {
+			case "ok":
+				ok = e == nil
+			case "write":
+				ok = errors.As(e, &we) && we.Index == i
+			case "skip":
+				ok = errors.Is(e, ErrNotExecuted)
+			case "wce":
+				ok = e == error(wce)
+			case "all":
+				ok = e == network
+			}
+			if !ok {
+				t.Errorf("%s: op %d = %v, want %s", c.name, i, e, w)
+			}
+		}
+	}
+}